@@ -6,21 +6,47 @@ import (
 	"os"
 
 	"github.com/wjase/diffyaml/pkg/diff"
+	"github.com/wjase/diffyaml/pkg/patch"
 	"github.com/wjase/diffyaml/pkg/report"
+	"gopkg.in/yaml.v3"
 )
 
 func main() {
+	// the first argument names a subcommand, so files called patch or merge
+	// are diffed by putting -- before them
+	if len(os.Args) > 1 && os.Args[1] == "patch" {
+		patchMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		mergeMain(os.Args[2:])
+		return
+	}
 	// numbPtr := flag.Int("numb", 42, "an int")
 	// boolPtr := flag.Bool("fork", false, "a bool")
 	// var outputfile string
 	// flag.StringVar(&svar, "svar", "bar", "a string var")
+	diffOptions := addDiffFlags(flag.CommandLine)
+	full := flag.Bool("full", false,
+		"write every change with its old and new values, as diffyaml patch reads them")
+	jsonPatch := flag.Bool("json-patch", false,
+		"write the changes as an RFC 6902 JSON Patch")
+	jsonPatchTests := flag.Bool("json-patch-tests", false,
+		"with --json-patch, check each old value with a test operation before changing it")
+	mergePatch := flag.Bool("merge-patch", false,
+		"write the changes as a yaml overlay of an RFC 7396 JSON Merge Patch, where null deletes a key")
+	mergePatchJSON := flag.Bool("merge-patch-json", false,
+		"like --merge-patch, but written as json")
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), `
 diffyam - list the structured changes between two yaml files.
            Outputs a report of the changelog as a yaml file.
 
-Syntax: diffyam  yamlfile1 yamlfile2
+Syntax: diffyam [options] yamlfile1 yamlfile2
+        diffyam patch yamlfile changelogfile
+        diffyam merge [options] basefile oursfile theirsfile
 
+Put -- before the yaml files to diff files called patch or merge.
 
 `)
 
@@ -33,16 +59,40 @@ Syntax: diffyam  yamlfile1 yamlfile2
 	if len(args) < 2 {
 		fmt.Fprintf(flag.CommandLine.Output(), "Error: Two args required\n")
 		flag.Usage()
+		os.Exit(-1)
 	}
 	oldSpec := args[0]
 	newSpec := args[1]
 
-	changes, err := diff.GetYamlFileChanges(oldSpec, newSpec)
+	opts, err := diffOptions.options()
 	if err != nil {
 		fmt.Printf("ERROR: %v", err)
 		os.Exit(-1)
 	}
 
-	report.WriteChanges(changes, os.Stdout)
+	changes, err := diff.GetYamlFileChanges(oldSpec, newSpec, opts...)
+	if err != nil {
+		fmt.Printf("ERROR: %v", err)
+		os.Exit(-1)
+	}
+
+	switch {
+	case *mergePatch || *mergePatchJSON:
+		// the new document holds the sequences which are replaced whole
+		var newDoc *yaml.Node
+		if newDoc, err = readDocument(newSpec); err == nil {
+			err = report.WriteMergePatch(changes, newDoc, os.Stdout, *mergePatchJSON)
+		}
+	case *jsonPatch:
+		err = report.WriteJSONPatch(changes, os.Stdout, *jsonPatchTests)
+	case *full:
+		err = patch.WriteChangelog(changes, os.Stdout)
+	default:
+		err = report.WriteChanges(changes, os.Stdout)
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %v", err)
+		os.Exit(-1)
+	}
 
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+// stringList collects the values of a flag which may be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// diffFlags are the flags for the options of the diff, which the commands
+// comparing documents share
+type diffFlags struct {
+	matchResources   *bool
+	semantic         *bool
+	similarity       *float64
+	renameSimilarity *float64
+	comments         *bool
+	styles           *bool
+	ignores          stringList
+	ignoreFile       *string
+	unordered        stringList
+	lineDiffs        *bool
+	maxNodes         *int
+	maxDepth         *int
+	maxAliases       *int
+	timeout          *time.Duration
+	costLimit        *int
+	identities       stringList
+}
+
+// addDiffFlags defines the flags for the options of the diff
+func addDiffFlags(flags *flag.FlagSet) *diffFlags {
+	f := &diffFlags{}
+	f.matchResources = flags.Bool("match-resources", false,
+		"pair the documents in multi-document files by their kubernetes apiVersion, kind, namespace and name")
+	f.semantic = flags.Bool("semantic", false,
+		"compare scalars by their resolved value so formatting only edits such as 1.0 to 1 or yes to true are ignored")
+	f.similarity = flags.Float64("similarity", 0,
+		"pair sequence items which were moved and edited when at least this fraction (0-1) of their contents is unchanged")
+	f.renameSimilarity = flags.Float64("rename-similarity", 0,
+		"report a deleted and an added key as renamed when at least this fraction (0-1) of their values is unchanged")
+	f.comments = flags.Bool("comments", false,
+		"report changes to the head, line and foot comments of each node")
+	f.styles = flags.Bool("styles", false,
+		"report changes to how values are written, such as quoting, flow style or literal and folded blocks")
+	flags.Var(&f.ignores, "ignore",
+		"skip the subtrees matching a path pattern, eg 'metadata.annotations.*' (repeatable)")
+	f.ignoreFile = flags.String("ignore-file", "",
+		"a file of path patterns to skip, one per line")
+	flags.Var(&f.unordered, "unordered",
+		"compare the sequences whose items match a path pattern as sets, eg '**.required[*]' (repeatable)")
+	f.lineDiffs = flags.Bool("line-diff", false,
+		"list the lines added to and removed from changed multi-line values instead of the whole values")
+	f.maxNodes = flags.Int("max-nodes", 0, "give up after hashing this many nodes, counting expanded aliases")
+	f.maxDepth = flags.Int("max-depth", 0, "give up on nodes nested more deeply than this")
+	f.maxAliases = flags.Int("max-aliases", diff.DefaultMaxAliasExpansions,
+		"give up after expanding this many aliases and merge keys, or 0 for no limit")
+	f.timeout = flags.Duration("timeout", 0, "give up after this long, eg 10s")
+	f.costLimit = flags.Int("sequence-cost-limit", 0,
+		"settle for a near minimal diff of sequences which differ by more than about this many items")
+	flags.Var(&f.identities, "identity",
+		"match the items of sequences by some of their fields, eg 'spec.template.spec.containers[*]=name' (repeatable)")
+	return f
+}
+
+// options returns the options of the diff which the flags set
+func (f *diffFlags) options() ([]diff.Option, error) {
+	opts := []diff.Option{}
+	if *f.matchResources {
+		opts = append(opts, diff.WithResourceMatching())
+	}
+	if *f.semantic {
+		opts = append(opts, diff.WithSemanticScalars())
+	}
+	if *f.similarity > 0 {
+		opts = append(opts, diff.WithSimilarityThreshold(*f.similarity))
+	}
+	if *f.renameSimilarity > 0 {
+		opts = append(opts, diff.WithRenameSimilarity(*f.renameSimilarity))
+	}
+	if *f.comments {
+		opts = append(opts, diff.WithComments())
+	}
+	if *f.styles {
+		opts = append(opts, diff.WithStyles())
+	}
+	ignores := append([]string{}, f.ignores...)
+	if *f.ignoreFile != "" {
+		patterns, err := readPatternFile(*f.ignoreFile)
+		if err != nil {
+			return nil, err
+		}
+		ignores = append(ignores, patterns...)
+	}
+	if len(ignores) > 0 {
+		opts = append(opts, diff.WithIgnoredPaths(ignores...))
+	}
+	if len(f.unordered) > 0 {
+		opts = append(opts, diff.WithUnorderedSequences(f.unordered...))
+	}
+	if *f.lineDiffs {
+		opts = append(opts, diff.WithLineDiffs())
+	}
+	opts = append(opts,
+		diff.WithMaxNodes(*f.maxNodes),
+		diff.WithMaxDepth(*f.maxDepth),
+		diff.WithMaxAliasExpansions(*f.maxAliases),
+		diff.WithTimeout(*f.timeout),
+		diff.WithSequenceCostLimit(*f.costLimit))
+	identityOpts, err := identityOptions(f.identities)
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, identityOpts...), nil
+}
+
+// identityOptions parses identity rules of the form pattern=field1,field2
+func identityOptions(rules []string) ([]diff.Option, error) {
+	opts := []diff.Option{}
+	for _, rule := range rules {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("identity rule %q should look like path=field1,field2", rule)
+		}
+		opts = append(opts, diff.WithIdentityKeys(parts[0], strings.Split(parts[1], ",")...))
+	}
+	return opts, nil
+}
+
+// readPatternFile reads one path pattern per line, skipping blank lines and
+// lines starting with #
+func readPatternFile(fileName string) ([]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	patterns := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// readDocument reads a yaml file which should hold a single document
+func readDocument(fileName string) (*yaml.Node, error) {
+	docs, err := diff.ReadYAMLStream(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) != 1 {
+		return nil, fmt.Errorf("%s holds %d documents, but only a single document can be used here", fileName, len(docs))
+	}
+	return docs[0], nil
+}
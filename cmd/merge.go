@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/wjase/diffyaml/pkg/merge"
+	"gopkg.in/yaml.v3"
+)
+
+// mergeMain merges the edits two files made to a base file. The conflicts
+// are reported on stderr, and the exit code is 1 when there are any.
+func mergeMain(args []string) {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	diffOptions := addDiffFlags(flags)
+	output := flags.String("output", "", "write the merged yaml to this file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), `
+diffyam merge - merge the changes two yaml files made to a base yaml file.
+                Outputs the merged yaml, with ours' version of any conflicts,
+                and lists the conflicts on stderr.
+
+Syntax: diffyam merge [options] basefile oursfile theirsfile
+
+
+`)
+
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 3 {
+		fmt.Fprintf(flags.Output(), "Error: Three args required\n")
+		flags.Usage()
+		os.Exit(-1)
+	}
+
+	opts, err := diffOptions.options()
+	if err != nil {
+		fmt.Printf("ERROR: %v", err)
+		os.Exit(-1)
+	}
+	docs := make([]*yaml.Node, 3)
+	for index := range docs {
+		if docs[index], err = readDocument(flags.Arg(index)); err != nil {
+			fmt.Printf("ERROR: %v", err)
+			os.Exit(-1)
+		}
+	}
+
+	merged, conflicts, err := merge.Merge(docs[0], docs[1], docs[2], opts...)
+	if err != nil {
+		fmt.Printf("ERROR: %v", err)
+		os.Exit(-1)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			fmt.Printf("ERROR: %v", err)
+			os.Exit(-1)
+		}
+	}
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(merged); err != nil {
+		fmt.Printf("ERROR: %v", err)
+		os.Exit(-1)
+	}
+	if err := encoder.Close(); err != nil {
+		fmt.Printf("ERROR: %v", err)
+		os.Exit(-1)
+	}
+	// closed rather than deferred, as exiting with the conflicts would skip it
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			fmt.Printf("ERROR: %v", err)
+			os.Exit(-1)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		if err := merge.WriteConflicts(conflicts, os.Stderr); err != nil {
+			fmt.Printf("ERROR: %v", err)
+			os.Exit(-1)
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/wjase/diffyaml/pkg/diff"
+	"github.com/wjase/diffyaml/pkg/patch"
+	"gopkg.in/yaml.v3"
+)
+
+// patchMain applies a changelog written with --full to a yaml file
+func patchMain(args []string) {
+	flags := flag.NewFlagSet("patch", flag.ExitOnError)
+	output := flags.String("output", "", "write the patched yaml to this file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), `
+diffyam patch - apply the changes listed by diffyam --full to a yaml file.
+                Outputs the patched yaml.
+
+Syntax: diffyam patch [options] yamlfile changelogfile
+
+
+`)
+
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		fmt.Fprintf(flags.Output(), "Error: Two args required\n")
+		flags.Usage()
+		os.Exit(-1)
+	}
+
+	docs, err := diff.ReadYAMLStream(flags.Arg(0))
+	if err != nil {
+		fmt.Printf("ERROR: %v", err)
+		os.Exit(-1)
+	}
+	changelog, err := os.Open(flags.Arg(1))
+	if err != nil {
+		fmt.Printf("ERROR: %v", err)
+		os.Exit(-1)
+	}
+	changes, err := patch.ReadChangelog(changelog)
+	changelog.Close()
+	if err != nil {
+		fmt.Printf("ERROR: %v", err)
+		os.Exit(-1)
+	}
+
+	patched, err := patch.ApplyStream(docs, changes)
+	if err != nil {
+		fmt.Printf("ERROR: %v", err)
+		os.Exit(-1)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			fmt.Printf("ERROR: %v", err)
+			os.Exit(-1)
+		}
+		defer out.Close()
+	}
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	for _, doc := range patched {
+		if err := encoder.Encode(doc); err != nil {
+			fmt.Printf("ERROR: %v", err)
+			os.Exit(-1)
+		}
+	}
+	encoder.Close()
+}
@@ -34,7 +34,9 @@ type Diff struct {
 
 // FromArrayStruct utility struct to encompass diffing of string arrays
 type FromArrayStruct struct {
-	from []string
+	from      []string
+	costLimit int
+	stop      func() bool
 }
 
 // Max returns the larger of x or y.
@@ -47,7 +49,26 @@ func Max(x, y int) int {
 
 // FromStringArray starts a fluent diff expression
 func FromStringArray(from []string) FromArrayStruct {
-	return FromArrayStruct{from}
+	return FromArrayStruct{from: from}
+}
+
+// WithCostLimit bounds the work done diffing very different arrays. Once the
+// search for the middle of the edit script has cost more than the limit
+// without finding it, the arrays are split where the search got furthest, in
+// the manner of GNU diff's heuristic. The result is still a valid edit script
+// but may not be minimal. Zero, the default, always finds a minimal one.
+func (f FromArrayStruct) WithCostLimit(costLimit int) FromArrayStruct {
+	f.costLimit = costLimit
+	return f
+}
+
+// WithStop lets the caller give up on diffing very long arrays, eg when a
+// deadline passes. The stop function is polled as the search goes on, and
+// once it returns true the remaining items are reported deleted and added.
+// The result is still a valid edit script but may be far from minimal.
+func (f FromArrayStruct) WithStop(stop func() bool) FromArrayStruct {
+	f.stop = stop
+	return f
 }
 
 // DiffsTo returns a set of diffs from the original array to the
@@ -69,7 +90,7 @@ func (f FromArrayStruct) DiffsTo(toArray []string) []Diff {
 		return allDeleted(f.from)
 	}
 
-	return diff(f.from, toArray)
+	return diff(f.from, toArray, search{costLimit: f.costLimit, stop: f.stop})
 
 }
 
@@ -147,8 +168,8 @@ func (f FromMapStruct) DiffsTo(destMap map[string]interface{}) (added, deleted,
 //  Returns a minimal list of differences between 2 lists e and f
 //  requring O(min(len(e),len(f))) space and O(min(len(e),len(f)) * D)
 //  worst-case execution time where D is the number of differences.
-func diff(e, f []string) []Diff {
-	diffs := recDiff(e, f, 0, 0)
+func diff(e, f []string, s search) []Diff {
+	diffs := recDiff(e, f, 0, 0, s)
 	for ind, eachDiff := range diffs {
 		if eachDiff.Code == AddItem {
 			eachDiff.ToValue = f[eachDiff.ToIndex]
@@ -179,7 +200,18 @@ func pyMod(d, m int) int {
 	return res
 }
 
-func recDiff(list1, list2 []string, i, j int) []Diff {
+// search holds the bounds on the work recDiff does
+type search struct {
+	costLimit int
+	stop      func() bool
+}
+
+// stopped reports whether the caller has given up on the diff
+func (s search) stopped() bool {
+	return s.stop != nil && s.stop()
+}
+
+func recDiff(list1, list2 []string, i, j int, bounds search) []Diff {
 	diffs := []Diff{}
 	//  Documented at http://blog.robertelder.org/diff-algorithm/
 	N, M, L, Z := len(list1), len(list2), len(list1)+len(list2), 2*Min(len(list1), len(list2))+2
@@ -187,6 +219,19 @@ func recDiff(list1, list2 []string, i, j int) []Diff {
 		w, g, p := N-M, make([]int, Z), make([]int, Z)
 		// for h := 0; h < (L/2+(L%2))+1; h++ {
 		for h := 0; h < (L/2+(pyMod(L, 2)))+1; h++ {
+			if bounds.stopped() {
+				return append(recDiff(list1, []string{}, i, j, bounds), recDiff([]string{}, list2, i+N, j, bounds)...)
+			}
+			if bounds.costLimit > 0 && h > bounds.costLimit {
+				// too expensive, so split where the forward search got furthest
+				x, y := furthestForward(g, h-1, N, M, Z)
+				if x+y == 0 || (x == N && y == M) {
+					return append(recDiff(list1, []string{}, i, j, bounds), recDiff([]string{}, list2, i+N, j, bounds)...)
+				}
+				diffs = append(diffs, recDiff(list1[0:x], list2[0:y], i, j, bounds)...)
+				diffs = append(diffs, recDiff(list1[x:N], list2[y:M], i+x, j+y, bounds)...)
+				return diffs
+			}
 			for r := 0; r < 2; r++ {
 				c, d, o, m := g, p, 1, 1
 				if r != 0 {
@@ -212,13 +257,13 @@ func recDiff(list1, list2 []string, i, j int) []Diff {
 							D, x, y, u, v = 2*h-1, s, t, a, b
 						}
 						if D > 1 || (x != u && y != v) {
-							diffs = append(diffs, recDiff(list1[0:x], list2[0:y], i, j)...)
-							diffs = append(diffs, recDiff(list1[u:N], list2[v:M], i+u, j+v)...)
+							diffs = append(diffs, recDiff(list1[0:x], list2[0:y], i, j, bounds)...)
+							diffs = append(diffs, recDiff(list1[u:N], list2[v:M], i+u, j+v, bounds)...)
 							return diffs
 						} else if M > N {
-							return recDiff([]string{}, list2[N:M], i+N, j+N)
+							return recDiff([]string{}, list2[N:M], i+N, j+N, bounds)
 						} else if M < N {
-							return recDiff(list1[M:N], []string{}, i+M, j+M)
+							return recDiff(list1[M:N], []string{}, i+M, j+M, bounds)
 						}
 						return diffs
 					}
@@ -237,3 +282,20 @@ func recDiff(list1, list2 []string, i, j int) []Diff {
 	}
 	return diffs
 }
+
+// furthestForward returns the point on the forward search's frontier after
+// h steps which is furthest from the start
+func furthestForward(g []int, h, N, M, Z int) (int, int) {
+	bestX, bestY := 0, 0
+	for k := -(h - 2*Max(0, h-M)); k < h-2*Max(0, h-N)+1; k = k + 2 {
+		x := Min(g[pyMod(k, Z)], N)
+		y := x - k
+		if y < 0 || y > M {
+			continue
+		}
+		if x+y > bestX+bestY {
+			bestX, bestY = x, y
+		}
+	}
+	return bestX, bestY
+}
@@ -1,6 +1,9 @@
 package array
 
 import (
+	"fmt"
+	"math/rand"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -123,3 +126,96 @@ func TestMapDiff(t *testing.T) {
 	commonDiffs = map[string]interface{}{"abc": Pair{1, 2}, "ghi": Pair{3, 3}, "jkl": Pair{4, 4}}
 	require.Equal(t, commonDiffs, common)
 }
+
+// applyDiffs applies an edit script to the from array
+func applyDiffs(from []string, diffs []Diff) []string {
+	deleted := map[int]bool{}
+	added := []Diff{}
+	for _, each := range diffs {
+		if each.Code == DeleteItem {
+			deleted[each.FromIndex] = true
+		} else {
+			added = append(added, each)
+		}
+	}
+	result := []string{}
+	for index, item := range from {
+		if !deleted[index] {
+			result = append(result, item)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].ToIndex < added[j].ToIndex })
+	for _, each := range added {
+		result = append(result[:each.ToIndex], append([]string{each.ToValue}, result[each.ToIndex:]...)...)
+	}
+	return result
+}
+
+func randomArray(random *rand.Rand, size int) []string {
+	ary := make([]string, size)
+	for index := range ary {
+		ary[index] = fmt.Sprintf("%c", 'a'+random.Intn(4))
+	}
+	return ary
+}
+
+func TestCostLimitedDiff(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for run := 0; run < 500; run++ {
+		from, to := randomArray(random, random.Intn(40)), randomArray(random, random.Intn(40))
+		minimal := FromStringArray(from).DiffsTo(to)
+		require.Equal(t, to, applyDiffs(from, minimal))
+		for _, costLimit := range []int{1, 2, 5} {
+			diffs := FromStringArray(from).WithCostLimit(costLimit).DiffsTo(to)
+			require.Equal(t, to, applyDiffs(from, diffs), "from %v to %v limit %d", from, to, costLimit)
+			require.GreaterOrEqual(t, len(diffs), len(minimal))
+		}
+	}
+}
+
+func TestCostLimitBoundsReversedArrays(t *testing.T) {
+	from := make([]string, 20000)
+	for index := range from {
+		from[index] = fmt.Sprint(index)
+	}
+	to := make([]string, len(from))
+	for index, item := range from {
+		to[len(to)-1-index] = item
+	}
+	diffs := FromStringArray(from).WithCostLimit(64).DiffsTo(to)
+	require.Equal(t, to, applyDiffs(from, diffs))
+}
+
+func TestStoppedDiffIsStillValid(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for run := 0; run < 200; run++ {
+		from, to := randomArray(random, random.Intn(40)), randomArray(random, random.Intn(40))
+		for _, after := range []int{0, 1, 3} {
+			polls := 0
+			stop := func() bool {
+				polls++
+				return polls > after
+			}
+			diffs := FromStringArray(from).WithStop(stop).DiffsTo(to)
+			require.Equal(t, to, applyDiffs(from, diffs), "from %v to %v stopped after %d", from, to, after)
+		}
+	}
+}
+
+func BenchmarkDiffReversed(b *testing.B) {
+	from := make([]string, 5000)
+	for index := range from {
+		from[index] = fmt.Sprint(index)
+	}
+	to := make([]string, len(from))
+	for index, item := range from {
+		to[len(to)-1-index] = item
+	}
+	for _, costLimit := range []int{0, 256, 64} {
+		b.Run(fmt.Sprintf("costLimit=%d", costLimit), func(b *testing.B) {
+			for ind := 0; ind < b.N; ind++ {
+				FromStringArray(from).WithCostLimit(costLimit).DiffsTo(to)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package diff
 
-import "gopkg.in/yaml.v3"
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
 
 // ChangeType describes the change
 type ChangeType int
@@ -16,10 +20,29 @@ const (
 	Moved
 	//Changed item was modified
 	Changed
+	//KindChanged item was replaced by a different kind of node, eg a scalar by a mapping
+	KindChanged
+	//TypeChanged scalar value is the same but its resolved tag changed, eg "8080" to 8080
+	TypeChanged
+	//Renamed mapping key was renamed, its value may have changed too
+	Renamed
+	//CommentChanged the comments about a node changed
+	CommentChanged
+	//StyleChanged the node is written in a different style
+	StyleChanged
 )
 
 // ChangeTypeLabels used for printing changes
-var ChangeTypeLabels = []string{"no-change", "added", "deleted", "moved", "changed"}
+var ChangeTypeLabels = []string{"no-change", "added", "deleted", "moved", "changed", "kind-changed", "type-changed", "renamed", "comment-changed", "style-changed"}
+
+// KindLabels used for printing yaml node kinds
+var KindLabels = map[yaml.Kind]string{
+	yaml.DocumentNode: "document",
+	yaml.SequenceNode: "sequence",
+	yaml.MappingNode:  "mapping",
+	yaml.ScalarNode:   "scalar",
+	yaml.AliasNode:    "alias",
+}
 
 // String implement the Stringer interface
 func (d ChangeType) String() string {
@@ -31,16 +54,41 @@ func (d ChangeType) MarshalYAML() (interface{}, error) {
 	return ChangeTypeLabels[d], nil
 }
 
+// UnmarshalYAML reads change types from their labels
+func (d *ChangeType) UnmarshalYAML(value *yaml.Node) error {
+	for changeType, label := range ChangeTypeLabels {
+		if label == value.Value {
+			*d = ChangeType(changeType)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown change type %q", value.Value)
+}
+
 // ChangeLogEntry info on a changed node
 type ChangeLogEntry struct {
-	Path       string
-	ChangeType ChangeType `yaml:"type,omitempty"`
-	From       *yaml.Node `yaml:"from,omitempty"`
-	To         *yaml.Node `yaml:"to,omitempty"`
-	FromIndex  *int       `yaml:"from-index,omitempty"`
-	ToIndex    *int       `yaml:"to-index,omitempty"`
-	Line       *int       `yaml:"line,omitempty"`
-	Column     *int       `yaml:"column,omitempty"`
+	Path        Path
+	ChangeType  ChangeType   `yaml:"type,omitempty"`
+	From        *yaml.Node   `yaml:"from,omitempty"`
+	To          *yaml.Node   `yaml:"to,omitempty"`
+	FromIndex   *int         `yaml:"from-index,omitempty"`
+	ToIndex     *int         `yaml:"to-index,omitempty"`
+	Line        *int         `yaml:"line,omitempty"`
+	Column      *int         `yaml:"column,omitempty"`
+	Anchor      string       `yaml:"anchor,omitempty"`
+	FromKind    string       `yaml:"from-kind,omitempty"`
+	ToKind      string       `yaml:"to-kind,omitempty"`
+	FromTag     string       `yaml:"from-tag,omitempty"`
+	ToTag       string       `yaml:"to-tag,omitempty"`
+	FromKey     string       `yaml:"from-key,omitempty"`
+	ToKey       string       `yaml:"to-key,omitempty"`
+	FromComment string       `yaml:"from-comment,omitempty"`
+	ToComment   string       `yaml:"to-comment,omitempty"`
+	FromStyle   string       `yaml:"from-style,omitempty"`
+	ToStyle     string       `yaml:"to-style,omitempty"`
+	FromCount   *int         `yaml:"from-count,omitempty"`
+	ToCount     *int         `yaml:"to-count,omitempty"`
+	LineDiff    []LineChange `yaml:"line-diff,omitempty"`
 }
 
 // ChangeLogEntries custom collection type
@@ -53,7 +101,7 @@ func (l ChangeLogEntries) Len() int {
 // Less reports whether the element with
 // index i should sort before the element with index j.
 func (l ChangeLogEntries) Less(i, j int) bool {
-	return l[i].Path < l[j].Path
+	return l[i].Path.Less(l[j].Path)
 }
 
 // Swap swaps the elements with indexes i and j.
@@ -0,0 +1,45 @@
+package diff
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// commentText joins all the comments written about a node. A mapping value's
+// head comment usually belongs to its key, so the key's comments are included.
+func commentText(node *HashedNode) string {
+	comments := []string{}
+	for _, each := range []*yaml.Node{node.KeyNode, node.Node} {
+		if each == nil {
+			continue
+		}
+		for _, comment := range []string{each.HeadComment, each.LineComment, each.FootComment} {
+			if comment != "" {
+				comments = append(comments, comment)
+			}
+		}
+	}
+	return strings.Join(comments, "\n")
+}
+
+// diffComments reports a change to the comments about a node
+func diffComments(node1, node2 *HashedNode) ChangeLogEntries {
+	fromComment, toComment := commentText(node1), commentText(node2)
+	if fromComment == toComment {
+		return nil
+	}
+	line, column := node2.Node.Line, node2.Node.Column
+	if node2.KeyNode != nil {
+		line, column = node2.KeyNode.Line, node2.KeyNode.Column
+	}
+	return ChangeLogEntries{{
+		Path:        node2.Path(),
+		ChangeType:  CommentChanged,
+		FromComment: fromComment,
+		ToComment:   toComment,
+		Line:        &line,
+		Column:      &column,
+		Anchor:      anchorOf(node2, node1),
+	}}
+}
@@ -1,69 +1,300 @@
 package diff
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"os"
 
 	"github.com/wjase/diffyaml/pkg/array"
 	"gopkg.in/yaml.v3"
 )
 
-// GetYamlFileChanges loads the specs and compares them
-func GetYamlFileChanges(oldSpec, newSpec string) (ChangeLogEntries, error) {
-	spec1, err := ReadYAMLFile(oldSpec)
+// GetYamlFileChanges loads the specs and compares them document by document
+func GetYamlFileChanges(oldSpec, newSpec string, opts ...Option) (ChangeLogEntries, error) {
+	return GetYamlFileChangesContext(context.Background(), oldSpec, newSpec, opts...)
+}
+
+// GetYamlFileChangesContext loads the specs and compares them document by
+// document until the context is done or one of the resource limits is exceeded
+func GetYamlFileChangesContext(ctx context.Context, oldSpec, newSpec string, opts ...Option) (ChangeLogEntries, error) {
+	spec1, err := ReadYAMLStream(oldSpec)
 	if err != nil {
 		return nil, err
 	}
-	spec2, err := ReadYAMLFile(newSpec)
+	spec2, err := ReadYAMLStream(newSpec)
 	if err != nil {
 		return nil, err
 	}
-	changes, err := GetYamlNodeChanges(spec1, spec2)
+	changes, err := GetYamlStreamChangesContext(ctx, spec1, spec2, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return changes, nil
 }
 
-// GetYamlNodeChanges returns the changes between the two yaml documents
-func GetYamlNodeChanges(doc1, doc2 *yaml.Node) (ChangeLogEntries, error) {
+// GetYamlStreamChanges returns the changes between two multi-document yaml
+// streams. Documents are paired by position unless resource matching is enabled.
+// When either stream holds more than one document the paths are prefixed with
+// the document index, eg doc[1].a.b
+func GetYamlStreamChanges(docs1, docs2 []*yaml.Node, opts ...Option) (ChangeLogEntries, error) {
+	return GetYamlStreamChangesContext(context.Background(), docs1, docs2, opts...)
+}
+
+// GetYamlStreamChangesContext returns the changes between two multi-document
+// yaml streams, like GetYamlStreamChanges. It gives up with the context's
+// error when the context is done, or with a *LimitError when one of the
+// resource limits in the options is exceeded.
+func GetYamlStreamChangesContext(ctx context.Context, docs1, docs2 []*yaml.Node, opts ...Option) (ChangeLogEntries, error) {
+	d := newDiffer(opts)
+	limitedCtx, cancel := withTimeLimit(ctx, d.options)
+	defer cancel()
+	d.guard = newGuard(limitedCtx, ctx, d.options)
+
+	multiDoc := len(docs1) > 1 || len(docs2) > 1
+	hashed1 := d.hashDocuments(docs1, multiDoc)
+	hashed2 := d.hashDocuments(docs2, multiDoc)
+	if d.guard.err != nil {
+		return nil, d.guard.err
+	}
+
+	var changes ChangeLogEntries
+	if d.options.MatchResources {
+		changes = d.diffDocumentsByResource(hashed1, hashed2)
+	} else {
+		changes = d.diffDocumentsByPosition(hashed1, hashed2)
+	}
+	if !d.guard.checkContext(func() string { return "doc." }) {
+		return nil, d.guard.err
+	}
+	return changes, nil
+}
+
+func (d *differ) diffDocumentsByPosition(hashed1, hashed2 HashedNodes) ChangeLogEntries {
 	changes := ChangeLogEntries{}
-	hashed1 := HashNode(doc1)
-	hashed2 := HashNode(doc2)
+	for index := 0; index < len(hashed1) && index < len(hashed2); index++ {
+		changes = append(changes, d.diffNode(hashed1[index], hashed2[index])...)
+	}
+	for index := len(hashed2); index < len(hashed1); index++ {
+		changes = append(changes, documentChange(Deleted, index, hashed1[index]))
+	}
+	for index := len(hashed1); index < len(hashed2); index++ {
+		changes = append(changes, documentChange(Added, index, hashed2[index]))
+	}
+	return changes
+}
 
-	changes = diffNode(hashed1, hashed2)
+func (d *differ) hashDocuments(docs []*yaml.Node, multiDoc bool) HashedNodes {
+	hashed := make(HashedNodes, len(docs))
+	for index, doc := range docs {
+		docKey := ""
+		if multiDoc {
+			docKey = fmt.Sprintf("[%d]", index)
+		}
+		hashed[index] = hashDocument(doc, docKey, d.options, d.guard)
+	}
+	return hashed
+}
+
+// documentChange reports a whole document being added or deleted
+func documentChange(changeType ChangeType, index int, doc *HashedNode) ChangeLogEntry {
+	root := documentRoot(doc)
+	docIndex := index
+	entry := ChangeLogEntry{
+		Path:       DocumentPath(index),
+		ChangeType: changeType,
+		Line:       &root.Line,
+		Column:     &root.Column,
+	}
+	if changeType == Added {
+		entry.To = root
+		entry.ToIndex = &docIndex
+	} else {
+		entry.From = root
+		entry.FromIndex = &docIndex
+	}
+	return entry
+}
+
+// documentRoot returns the top level node held by a document
+func documentRoot(doc *HashedNode) *yaml.Node {
+	if len(doc.Node.Content) > 0 {
+		return doc.Node.Content[0]
+	}
+	return doc.Node
+}
+
+// GetYamlNodeChanges returns the changes between the two yaml documents
+func GetYamlNodeChanges(doc1, doc2 *yaml.Node, opts ...Option) (ChangeLogEntries, error) {
+	return GetYamlNodeChangesContext(context.Background(), doc1, doc2, opts...)
+}
+
+// GetYamlNodeChangesContext returns the changes between the two yaml
+// documents. It gives up with the context's error when the context is done,
+// or with a *LimitError when one of the resource limits in the options is
+// exceeded.
+func GetYamlNodeChangesContext(ctx context.Context, doc1, doc2 *yaml.Node, opts ...Option) (ChangeLogEntries, error) {
+	d := newDiffer(opts)
+	limitedCtx, cancel := withTimeLimit(ctx, d.options)
+	defer cancel()
+	d.guard = newGuard(limitedCtx, ctx, d.options)
+
+	hashed1 := hashDocument(doc1, "", d.options, d.guard)
+	hashed2 := hashDocument(doc2, "", d.options, d.guard)
+	if d.guard.err != nil {
+		return nil, d.guard.err
+	}
+
+	changes := d.diffNode(hashed1, hashed2)
+	if !d.guard.checkContext(func() string { return "doc." }) {
+		return nil, d.guard.err
+	}
 	return changes, nil
 }
 
-func diffNode(node1, node2 *HashedNode) ChangeLogEntries {
+// differ compares hashed documents according to the diff Options
+type differ struct {
+	options Options
+	guard   *guard
+}
+
+func newDiffer(opts []Option) *differ {
+	return &differ{options: newOptions(opts)}
+}
+
+// diffStrings diffs two lists within the sequence cost limit, giving up when
+// the time limit passes or the diff is cancelled. The path is where the lists
+// are, for reporting the time limit.
+func (d *differ) diffStrings(from, to []string, path func() string) []array.Diff {
+	stop := func() bool { return !d.guard.checkContext(path) }
+	return array.FromStringArray(from).WithCostLimit(d.options.SequenceCostLimit).WithStop(stop).DiffsTo(to)
+}
+
+// itemsPath returns the path of the sequence holding the items
+func itemsPath(items HashedNodes) func() string {
+	return func() string {
+		if len(items) == 0 {
+			return "doc."
+		}
+		return items[0].Path().Parent().String()
+	}
+}
+
+func (d *differ) diffNode(node1, node2 *HashedNode) ChangeLogEntries {
 	changes := ChangeLogEntries{}
+	if !d.guard.checkContext(func() string { return node2.Path().String() }) {
+		return changes
+	}
+	if node1.Node.Kind != node2.Node.Kind {
+		return append(changes, kindChange(node1, node2))
+	}
+	if d.options.Comments {
+		changes = append(changes, diffComments(node1, node2)...)
+	}
+	if d.options.Styles {
+		changes = append(changes, diffStyles(node1, node2)...)
+	}
 	switch node1.Node.Kind {
 	case yaml.DocumentNode:
-		childChanges := diffSequenceChildren(node1.Children, node2.Children)
+		// a document's content isn't a sequence, so the sequence rules don't
+		// apply to it
+		if len(node1.Children) == 1 && len(node2.Children) == 1 {
+			changes = append(changes, d.diffNode(node1.Children[0], node2.Children[0])...)
+			break
+		}
+		childChanges := d.diffSequenceChildren(node1.Children, node2.Children)
 		changes = append(changes, childChanges...)
 	case yaml.SequenceNode:
-		childChanges := diffSequenceChildren(node1.Children, node2.Children)
+		childChanges := d.diffSequenceChildren(node1.Children, node2.Children)
 		changes = append(changes, childChanges...)
 	case yaml.MappingNode:
-		childChanges := diffMappedChildren(node1.Children, node2.Children)
+		childChanges := d.diffMappedChildren(node1.Children, node2.Children)
 		changes = append(changes, childChanges...)
 	case yaml.ScalarNode:
-		if string(node1.Hash) != string(node2.Hash) {
+		if node1.contentHash != node2.contentHash {
+			changes = append(changes, d.withLineDiff(scalarChange(node1, node2)))
+		}
+	case yaml.AliasNode:
+		// recursive aliases are only compared by name
+		if node1.contentHash != node2.contentHash {
 			changes = append(changes, ChangeLogEntry{
-				Path:       node2.GetPath().String(),
+				Path:       node2.Path(),
 				ChangeType: Changed,
 				From:       node1.Node,
 				To:         node2.Node,
 				Line:       &node2.Node.Line,
 				Column:     &node2.Node.Column,
+				Anchor:     anchorOf(node2, node1),
 			})
 		}
-	case yaml.AliasNode:
-		// don't handle these yet
 	}
 	changes = prune(changes)
 	return changes
 }
 
+// scalarChange reports a changed scalar. When only the tag changed, eg
+// "8080" to 8080, it is reported as a type change.
+func scalarChange(node1, node2 *HashedNode) ChangeLogEntry {
+	if node1.Node.Value == node2.Node.Value {
+		return typeChange(node1, node2)
+	}
+	change := ChangeLogEntry{
+		Path:       node2.Path(),
+		ChangeType: Changed,
+		From:       node1.Node,
+		To:         node2.Node,
+		Line:       &node2.Node.Line,
+		Column:     &node2.Node.Column,
+		Anchor:     anchorOf(node2, node1),
+	}
+	if fromTag, toTag := node1.Node.ShortTag(), node2.Node.ShortTag(); fromTag != toTag {
+		change.FromTag = fromTag
+		change.ToTag = toTag
+	}
+	return change
+}
+
+// typeChange reports a scalar whose value is unchanged but whose resolved tag differs
+func typeChange(node1, node2 *HashedNode) ChangeLogEntry {
+	return ChangeLogEntry{
+		Path:       node2.Path(),
+		ChangeType: TypeChanged,
+		From:       node1.Node,
+		To:         node2.Node,
+		FromTag:    node1.Node.ShortTag(),
+		ToTag:      node2.Node.ShortTag(),
+		Line:       &node2.Node.Line,
+		Column:     &node2.Node.Column,
+		Anchor:     anchorOf(node2, node1),
+	}
+}
+
+// kindChange reports a node replaced by a different kind of node, along
+// with both subtrees
+func kindChange(node1, node2 *HashedNode) ChangeLogEntry {
+	return ChangeLogEntry{
+		Path:       node2.Path(),
+		ChangeType: KindChanged,
+		From:       node1.Node,
+		To:         node2.Node,
+		FromKind:   KindLabels[node1.Node.Kind],
+		ToKind:     KindLabels[node2.Node.Kind],
+		Line:       &node2.Node.Line,
+		Column:     &node2.Node.Column,
+		Anchor:     anchorOf(node2, node1),
+	}
+}
+
+// anchorOf returns the anchor of the first node which came from one
+func anchorOf(nodes ...*HashedNode) string {
+	for _, node := range nodes {
+		if node != nil && node.Anchor != "" {
+			return node.Anchor
+		}
+	}
+	return ""
+}
+
 func prune(changes ChangeLogEntries) ChangeLogEntries {
 	pruned := make(ChangeLogEntries, 0, len(changes))
 	for _, change := range changes {
@@ -76,29 +307,47 @@ func prune(changes ChangeLogEntries) ChangeLogEntries {
 
 // SequenceChangeLogEntry a changelog entry for a SequenceNode
 type SequenceChangeLogEntry struct {
-	Path               string
+	Path               Path
 	ChangeType         ChangeType `yaml:"type"`
 	From               *HashedNode
 	To                 *HashedNode
 	FromIndex, ToIndex int
+	// gap counts the unchanged items before a delete in the old sequence or
+	// an add in the new one. A delete and add in the same gap replace each
+	// other, whereas in different gaps the item moved.
+	gap int
 }
 
-func diffSequenceChildren(seq1, seq2 HashedNodes) ChangeLogEntries {
+func (d *differ) diffSequenceChildren(seq1, seq2 HashedNodes) ChangeLogEntries {
 
 	if len(seq1) == 0 && len(seq2) == 0 {
 		return ChangeLogEntries{}
 	}
+	// identity and set rules apply however few items there are, so an item
+	// whose identity changed is deleted and added rather than changed
+	if fields := d.identityFields(seq1, seq2); fields != nil {
+		return d.diffSequenceByIdentity(seq1, seq2, fields)
+	}
+	if d.isUnordered(seq1, seq2) {
+		return d.diffSequenceAsSet(seq1, seq2)
+	}
 	if len(seq1) == 1 && len(seq2) == 1 {
-		return diffNode(seq1[0], seq2[0])
+		return d.diffNode(seq1[0], seq2[0])
 	}
 
-	if seq1[0].IsScalar() && seq2[0].IsScalar() {
-		return diffScalarSequence(seq1, seq2)
-	}
+	// when one side is empty everything was added or deleted, so skip to the hash diff
+	if len(seq1) > 0 && len(seq2) > 0 {
+		if seq1[0].IsScalar() && seq2[0].IsScalar() {
+			return d.diffScalarSequence(seq1, seq2)
+		}
 
-	// its either a sequence of sequences or a sequence of mapping nodes
-	if seq1[0].Node.Kind == yaml.MappingNode && seq2[0].Node.Kind == yaml.MappingNode {
-		return diffSequenceOfMappingNodes(seq1, seq2)
+		// its either a sequence of sequences or a sequence of mapping nodes.
+		// Similar mapping nodes are paired by the hash diff below, which can
+		// follow them when they move.
+		if seq1[0].Node.Kind == yaml.MappingNode && seq2[0].Node.Kind == yaml.MappingNode &&
+			d.options.SimilarityThreshold == 0 {
+			return d.diffSequenceOfMappingNodes(seq1, seq2)
+		}
 	}
 
 	//diff by hash values
@@ -107,7 +356,7 @@ func diffSequenceChildren(seq1, seq2 HashedNodes) ChangeLogEntries {
 	hashSeq1 := hashList(seq1)
 	hashSeq2 := hashList(seq2)
 
-	hashDiffs := array.FromStringArray(hashSeq1).DiffsTo(hashSeq2)
+	hashDiffs := d.diffStrings(hashSeq1, hashSeq2, itemsPath(seq1))
 
 	// report changes
 	for _, item := range hashDiffs {
@@ -116,7 +365,7 @@ func diffSequenceChildren(seq1, seq2 HashedNodes) ChangeLogEntries {
 
 		if item.Code == array.DeleteItem {
 			hashedItem = seq1[item.FromIndex]
-			entry.Path = hashedItem.GetPath().String()
+			entry.Path = hashedItem.Path()
 			entry.ChangeType = Deleted
 			entry.From = hashedItem
 			entry.FromIndex = item.FromIndex
@@ -125,7 +374,7 @@ func diffSequenceChildren(seq1, seq2 HashedNodes) ChangeLogEntries {
 		}
 		if item.Code == array.AddItem {
 			hashedItem = seq2[item.ToIndex]
-			entry.Path = hashedItem.GetPath().String()
+			entry.Path = hashedItem.Path()
 			entry.ChangeType = Added
 			entry.To = hashedItem
 			entry.FromIndex = item.FromIndex
@@ -134,12 +383,62 @@ func diffSequenceChildren(seq1, seq2 HashedNodes) ChangeLogEntries {
 			changes = append(changes, entry)
 		}
 	}
-	mergedChanges := mergeMovedChanges(mergeUpdateChanges(changes))
+	setGaps(changes)
+	var mergedChanges []SequenceChangeLogEntry
+	if d.options.SimilarityThreshold > 0 {
+		// the items are paired by how similar they are, rather than guessed
+		// to be the same from their first keys
+		mergedChanges = mergeSimilarChanges(mergeMovedChanges(changes), d.options.SimilarityThreshold)
+	} else {
+		mergedChanges = mergeMovedChanges(mergeUpdateChanges(changes))
+	}
 
-	return toChangeLog(mergedChanges)
+	return append(d.toChangeLog(mergedChanges), d.diffAlignedItems(seq1, seq2, hashDiffs)...)
 }
 
-func diffSequenceOfMappingNodes(children1, children2 HashedNodes) ChangeLogEntries {
+// diffAlignedItems compares the items which the sequence diff left in place.
+// Their content is the same but their comments may not be.
+func (d *differ) diffAlignedItems(seq1, seq2 HashedNodes, diffs []array.Diff) ChangeLogEntries {
+	changes := ChangeLogEntries{}
+	for _, pair := range alignedPairs(len(seq1), len(seq2), diffs) {
+		if item1, item2 := seq1[pair[0]], seq2[pair[1]]; item1.Hash != item2.Hash {
+			changes = append(changes, d.diffNode(item1, item2)...)
+		}
+	}
+	return changes
+}
+
+// alignedPairs returns the indexes of the items which a diff leaves in place
+func alignedPairs(len1, len2 int, diffs []array.Diff) [][2]int {
+	deleted := map[int]bool{}
+	added := map[int]bool{}
+	for _, item := range diffs {
+		if item.Code == array.DeleteItem {
+			deleted[item.FromIndex] = true
+		}
+		if item.Code == array.AddItem {
+			added[item.ToIndex] = true
+		}
+	}
+	pairs := [][2]int{}
+	index2 := 0
+	for index1 := 0; index1 < len1; index1++ {
+		if deleted[index1] {
+			continue
+		}
+		for added[index2] {
+			index2++
+		}
+		if index2 >= len2 {
+			break
+		}
+		pairs = append(pairs, [2]int{index1, index2})
+		index2++
+	}
+	return pairs
+}
+
+func (d *differ) diffSequenceOfMappingNodes(children1, children2 HashedNodes) ChangeLogEntries {
 	seq1Map := map[string]interface{}{}
 	seq2Map := map[string]interface{}{}
 	for _, child := range children1 {
@@ -154,22 +453,24 @@ func diffSequenceOfMappingNodes(children1, children2 HashedNodes) ChangeLogEntri
 		addedIndex, hashedNode := children2.Find(eachKey)
 		changes = append(changes, ChangeLogEntry{
 			ChangeType: Added,
-			Path:       hashedNode.GetPath().String(),
+			Path:       hashedNode.Path(),
 			ToIndex:    &addedIndex,
 			To:         hashedNode.Node,
 			Line:       &hashedNode.Node.Line,
 			Column:     &hashedNode.Node.Column,
+			Anchor:     hashedNode.Anchor,
 		})
 	}
 	for eachKey := range deleted {
 		deletedIndex, hashedNode := children1.Find(eachKey)
 		changes = append(changes, ChangeLogEntry{
 			ChangeType: Deleted,
-			Path:       hashedNode.GetPath().String(),
+			Path:       hashedNode.Path(),
 			FromIndex:  &deletedIndex,
 			From:       hashedNode.Node,
 			Line:       &hashedNode.Node.Line,
 			Column:     &hashedNode.Node.Column,
+			Anchor:     hashedNode.Anchor,
 		})
 	}
 
@@ -177,25 +478,25 @@ func diffSequenceOfMappingNodes(children1, children2 HashedNodes) ChangeLogEntri
 		item1 := seq1Map[key].(*HashedNode)
 		item2 := seq2Map[key].(*HashedNode)
 		if item1.Hash != item2.Hash {
-			changes = append(changes, diffNode(item1, item2)...)
+			changes = append(changes, d.diffNode(item1, item2)...)
 		}
 	}
 
 	return changes
 }
 
-func diffScalarSequence(children1, children2 HashedNodes) ChangeLogEntries {
+func (d *differ) diffScalarSequence(children1, children2 HashedNodes) ChangeLogEntries {
 	seq1Values := make([]string, len(children1))
 	seq2Values := make([]string, len(children2))
 
 	for index, item := range children1 {
-		seq1Values[index] = item.Hash
+		seq1Values[index] = item.contentHash
 	}
 
 	for index, item := range children2 {
-		seq2Values[index] = item.Hash
+		seq2Values[index] = item.contentHash
 	}
-	diffs := array.FromStringArray(seq1Values).DiffsTo(seq2Values)
+	diffs := d.diffStrings(seq1Values, seq2Values, itemsPath(children1))
 
 	changes := make(ChangeLogEntries, len(diffs))
 	for index, diff := range diffs {
@@ -203,39 +504,88 @@ func diffScalarSequence(children1, children2 HashedNodes) ChangeLogEntries {
 		change := diffToChange(diff)
 
 		if change.ToIndex != nil {
-			change.Path = children2[*change.ToIndex].GetPath().String()
+			change.Path = children2[*change.ToIndex].Path()
 			change.To = children2[*change.ToIndex].Node
 			change.Line = &change.To.Line
 			change.Column = &change.To.Column
+			change.Anchor = children2[*change.ToIndex].Anchor
 		}
 		if change.FromIndex != nil {
-			change.Path = children1[*change.FromIndex].GetPath().String()
+			change.Path = children1[*change.FromIndex].Path()
 			change.From = children1[*change.FromIndex].Node
 			change.Line = &change.From.Line
 			change.Column = &change.From.Column
+			change.Anchor = children1[*change.FromIndex].Anchor
 		}
 		changes[index] = change
 	}
 
 	// add + delete same value different index => move
+	deletedByHash := indexQueues{}
+	for deletedIndex, deleted := range changes {
+		if deleted.ChangeType == Deleted {
+			deletedByHash.push(children1[*deleted.FromIndex].contentHash, deletedIndex)
+		}
+	}
+	for addedIndex, added := range changes {
+		if added.ChangeType != Added {
+			continue
+		}
+		if deletedIndex, ok := deletedByHash.pop(children2[*added.ToIndex].contentHash); ok {
+			deleted := changes[deletedIndex]
+			item := changes[addedIndex]
+			item.Path = deleted.Path
+			item.ChangeType = Moved
+			item.FromIndex = deleted.FromIndex
+			item.ToIndex = added.ToIndex
+			item.Line = added.Line
+			item.Column = added.Column
+			item.To = nil
+			changes[deletedIndex].ChangeType = NoChange
+			changes[addedIndex] = item
+		}
+	}
+
+	changes = append(changes, d.diffAlignedItems(children1, children2, diffs)...)
+
+	// add + delete same scalar value different tag => type change. Other
+	// kinds of node have no value, and are left added and deleted.
+	deletedByValue := indexQueues{}
+	for deletedIndex, deleted := range changes {
+		if deleted.ChangeType == Deleted && children1[*deleted.FromIndex].IsScalar() {
+			deletedByValue.push(deleted.From.Value, deletedIndex)
+		}
+	}
+	oldGaps, newGaps := sequenceGaps(diffs)
 	for addedIndex, added := range changes {
-		if added.ChangeType == Added {
-			for deletedIndex, deleted := range changes {
-				if deleted.ChangeType == Deleted {
-					if added.To.Value == deleted.From.Value {
-						item := changes[addedIndex]
-						item.Path = deleted.Path
-						item.ChangeType = Moved
-						item.FromIndex = deleted.FromIndex
-						item.ToIndex = added.ToIndex
-						item.Line = added.Line
-						item.Column = added.Column
-						item.To = nil
-						changes[deletedIndex].ChangeType = NoChange
-						changes[addedIndex] = item
-					}
+		if added.ChangeType != Added || !children2[*added.ToIndex].IsScalar() {
+			continue
+		}
+		if deletedIndex, ok := deletedByValue.pop(added.To.Value); ok {
+			deleted := changes[deletedIndex]
+			item := typeChange(children1[*deleted.FromIndex], children2[*added.ToIndex])
+			if oldGaps[*deleted.FromIndex] != newGaps[*added.ToIndex] {
+				// it moved between the unchanged items too, so report the move
+				// under the old position and the type change under the new one
+				changes[deletedIndex] = ChangeLogEntry{
+					Path:       deleted.Path,
+					ChangeType: Moved,
+					From:       deleted.From,
+					To:         added.To,
+					FromIndex:  deleted.FromIndex,
+					ToIndex:    added.ToIndex,
+					Line:       added.Line,
+					Column:     added.Column,
+					Anchor:     deleted.Anchor,
+				}
+			} else {
+				if *deleted.FromIndex != *added.ToIndex {
+					item.FromIndex = deleted.FromIndex
+					item.ToIndex = added.ToIndex
 				}
+				changes[deletedIndex].ChangeType = NoChange
 			}
+			changes[addedIndex] = item
 		}
 	}
 
@@ -261,22 +611,21 @@ func diffToChange(diff array.Diff) ChangeLogEntry {
 	}
 }
 
-func toChangeLog(seqChanges []SequenceChangeLogEntry) ChangeLogEntries {
+func (d *differ) toChangeLog(seqChanges []SequenceChangeLogEntry) ChangeLogEntries {
 	changes := make(ChangeLogEntries, 0, len(seqChanges))
 	for _, c := range seqChanges {
 		if c.ChangeType == Changed {
 			if !c.From.IsScalar() {
-				changes = append(changes, diffNode(c.From, c.To)...)
+				changes = append(changes, d.diffNode(c.From, c.To)...)
 				continue
 			}
 		}
-		if c.ChangeType == Moved {
-			if c.From.Hash != c.To.Hash {
-				changes = append(changes, diffNode(c.From, c.To)...)
-				continue
-			}
+		var nested ChangeLogEntries
+		if c.ChangeType == Moved && c.From.Hash != c.To.Hash {
+			// moved and modified, so report the move then the changes under the new position
+			nested = d.diffNode(c.From, c.To)
 		}
-		entry := ChangeLogEntry{Path: c.Path, ChangeType: c.ChangeType}
+		entry := ChangeLogEntry{Path: c.Path, ChangeType: c.ChangeType, Anchor: anchorOf(c.To, c.From)}
 		if c.From != nil {
 			entry.From = c.From.Node
 			entry.Line = &c.From.Node.Line
@@ -296,33 +645,41 @@ func toChangeLog(seqChanges []SequenceChangeLogEntry) ChangeLogEntries {
 			entry.Column = &entry.From.Column
 		}
 
-		changes = append(changes, entry)
+		changes = append(changes, d.withLineDiff(entry))
+		changes = append(changes, nested...)
 	}
 	return changes
 }
 
+// mergeMovedChanges pairs each deleted item with an added item with the same
+// content as a move. The nth delete of some content is paired with the nth
+// add of it, so duplicates move predictably.
 func mergeMovedChanges(changes []SequenceChangeLogEntry) []SequenceChangeLogEntry {
+	added := indexQueues{}
+	for addIndex, eachAdd := range changes {
+		if eachAdd.ChangeType == Added {
+			added.push(eachAdd.To.contentHash, addIndex)
+		}
+	}
 	deletions := map[int]bool{}
 	updateChanges := []SequenceChangeLogEntry{}
 	for delIndex, eachDelete := range changes {
-		if eachDelete.ChangeType == Deleted {
-			for addIndex, eachAdd := range changes {
-				if eachAdd.ChangeType == Added {
-					if eachAdd.To.Hash == eachDelete.From.Hash {
-						updateChanges = append(updateChanges,
-							SequenceChangeLogEntry{
-								ChangeType: Moved,
-								Path:       eachDelete.Path,
-								FromIndex:  eachDelete.FromIndex,
-								ToIndex:    eachAdd.ToIndex,
-								From:       eachDelete.From,
-								To:         eachAdd.To,
-							})
-						deletions[addIndex] = true
-						deletions[delIndex] = true
-					}
-				}
-			}
+		if eachDelete.ChangeType != Deleted {
+			continue
+		}
+		if addIndex, ok := added.pop(eachDelete.From.contentHash); ok {
+			eachAdd := changes[addIndex]
+			updateChanges = append(updateChanges,
+				SequenceChangeLogEntry{
+					ChangeType: Moved,
+					Path:       eachDelete.Path,
+					FromIndex:  eachDelete.FromIndex,
+					ToIndex:    eachAdd.ToIndex,
+					From:       eachDelete.From,
+					To:         eachAdd.To,
+				})
+			deletions[addIndex] = true
+			deletions[delIndex] = true
 		}
 	}
 	mergedChanges := updateChanges
@@ -334,6 +691,24 @@ func mergeMovedChanges(changes []SequenceChangeLogEntry) []SequenceChangeLogEntr
 	return mergedChanges
 }
 
+// indexQueues holds, for each key, the indexes of the changes with that key in
+// the order they were pushed
+type indexQueues map[string][]int
+
+func (q indexQueues) push(key string, index int) {
+	q[key] = append(q[key], index)
+}
+
+// pop removes and returns the first index pushed with the key
+func (q indexQueues) pop(key string) (int, bool) {
+	indexes := q[key]
+	if len(indexes) == 0 {
+		return 0, false
+	}
+	q[key] = indexes[1:]
+	return indexes[0], true
+}
+
 func isSameSequenceIdentity(node1, node2 *HashedNode) bool {
 	if node1.Node.Kind != node2.Node.Kind {
 		return false
@@ -350,10 +725,10 @@ func isSameSequenceIdentity(node1, node2 *HashedNode) bool {
 		}
 		// assume the first key of a sequence of mapping nodes denotes identity
 		if len(node1.Children) > 0 && len(node2.Children) > 0 {
-			return node1.Children[0].Hash == node2.Children[0].Hash
+			return node1.Children[0].contentHash == node2.Children[0].contentHash
 		}
 	}
-	return node1.Hash == node2.Hash
+	return node1.contentHash == node2.contentHash
 }
 
 func mergeUpdateChanges(changes []SequenceChangeLogEntry) []SequenceChangeLogEntry {
@@ -373,7 +748,7 @@ func mergeUpdateChanges(changes []SequenceChangeLogEntry) []SequenceChangeLogEnt
 			eachAdd := changes[currentAddIndex]
 			if isSameSequenceIdentity(eachAdd.To, eachDelete.From) &&
 				eachAdd.To.Hash != eachDelete.From.Hash {
-				updateChanges = append(updateChanges, SequenceChangeLogEntry{ChangeType: Changed, Path: eachAdd.Path, From: eachDelete.From, To: eachAdd.To})
+				updateChanges = append(updateChanges, pairedChange(eachDelete, eachAdd))
 				deletions[currentAddIndex] = true
 				deletions[currentDelIndex] = true
 				currentAddIndex = -1
@@ -390,62 +765,136 @@ func mergeUpdateChanges(changes []SequenceChangeLogEntry) []SequenceChangeLogEnt
 	return mergedChanges
 }
 
+// sequenceGaps works out which gap between the unchanged items each deleted
+// and added index of a sequence diff is in
+func sequenceGaps(diffs []array.Diff) (map[int]int, map[int]int) {
+	oldGaps, newGaps := map[int]int{}, map[int]int{}
+	deletesBefore, addsBefore := 0, 0
+	for _, item := range diffs {
+		switch item.Code {
+		case array.DeleteItem:
+			oldGaps[item.FromIndex] = item.FromIndex - deletesBefore
+			deletesBefore++
+		case array.AddItem:
+			newGaps[item.ToIndex] = item.ToIndex - addsBefore
+			addsBefore++
+		}
+	}
+	return oldGaps, newGaps
+}
+
+// setGaps works out which gap between the unchanged items each delete and add is in
+func setGaps(changes []SequenceChangeLogEntry) {
+	deletesBefore, addsBefore := 0, 0
+	for ind, change := range changes {
+		switch change.ChangeType {
+		case Deleted:
+			changes[ind].gap = change.FromIndex - deletesBefore
+			deletesBefore++
+		case Added:
+			changes[ind].gap = change.ToIndex - addsBefore
+			addsBefore++
+		}
+	}
+}
+
+// pairedChange pairs a deleted and an added item which are the same item
+// modified. It moved too if it's in a different gap between the unchanged items.
+func pairedChange(eachDelete, eachAdd SequenceChangeLogEntry) SequenceChangeLogEntry {
+	if eachDelete.gap != eachAdd.gap {
+		return SequenceChangeLogEntry{
+			ChangeType: Moved,
+			Path:       eachDelete.Path,
+			From:       eachDelete.From,
+			To:         eachAdd.To,
+			FromIndex:  eachDelete.FromIndex,
+			ToIndex:    eachAdd.ToIndex,
+		}
+	}
+	return SequenceChangeLogEntry{
+		ChangeType: Changed,
+		Path:       eachAdd.Path,
+		From:       eachDelete.From,
+		To:         eachAdd.To,
+		FromIndex:  eachDelete.FromIndex,
+		ToIndex:    eachAdd.ToIndex,
+	}
+}
+
 func hashList(seq HashedNodes) []string {
 	hashSeq := make([]string, len(seq))
 	for ind, item := range seq {
-		hashSeq[ind] = string(item.Hash)
+		hashSeq[ind] = item.contentHash
 	}
 	return hashSeq
 }
 
-func toHashedMap(nodes HashedNodes) map[string]HashedNode {
-	mappedNodes := map[string]HashedNode{}
+func toHashedMap(nodes HashedNodes) map[string]*HashedNode {
+	mappedNodes := map[string]*HashedNode{}
 	for _, node := range nodes {
-		mappedNodes[node.Key] = *node
+		mappedNodes[node.Key] = node
 	}
 	return mappedNodes
 }
 
-func diffMappedChildren(children1, children2 HashedNodes) ChangeLogEntries {
+func (d *differ) diffMappedChildren(children1, children2 HashedNodes) ChangeLogEntries {
 	changes := ChangeLogEntries{}
 	map1 := toHashedMap(children1)
 	map2 := toHashedMap(children2)
 
-	for k, item1 := range map1 {
-		if item2, ok := map2[k]; !ok {
-			changes = append(changes, ChangeLogEntry{
-				Path:       item1.GetPath().String(),
-				ChangeType: Deleted,
-				From:       item1.Node,
-				Line:       &item1.Node.Line,
-				Column:     &item1.Node.Column,
-			})
+	deleted := HashedNodes{}
+	for _, item1 := range children1 {
+		if item2, ok := map2[item1.Key]; !ok {
+			deleted = deleted.Add(item1)
 		} else {
 			if item2.Hash != item1.Hash {
-				changes = append(changes, diffNode(&item1, &item2)...)
+				changes = append(changes, d.diffNode(item1, item2)...)
 			}
 		}
 	}
-	for k, item2 := range map2 {
-		if _, ok := map1[k]; !ok {
-			changes = append(changes, ChangeLogEntry{
-				Path:       item2.GetPath().String(),
-				ChangeType: Added,
-				To:         item2.Node,
-				Line:       &item2.Node.Line,
-				Column:     &item2.Node.Column,
-			})
+	added := HashedNodes{}
+	for _, item2 := range children2 {
+		if _, ok := map1[item2.Key]; !ok {
+			added = added.Add(item2)
 		}
 	}
+
+	renames, deleted, added := d.mergeRenamedKeys(deleted, added)
+	changes = append(changes, renames...)
+
+	for _, item1 := range deleted {
+		changes = append(changes, ChangeLogEntry{
+			Path:       item1.Path(),
+			ChangeType: Deleted,
+			From:       item1.Node,
+			Line:       &item1.Node.Line,
+			Column:     &item1.Node.Column,
+			Anchor:     item1.Anchor,
+		})
+	}
+	for _, item2 := range added {
+		changes = append(changes, ChangeLogEntry{
+			Path:       item2.Path(),
+			ChangeType: Added,
+			To:         item2.Node,
+			Line:       &item2.Node.Line,
+			Column:     &item2.Node.Column,
+			Anchor:     item2.Anchor,
+		})
+	}
 	return changes
 }
 
-// ReadYAMLFile Reads a YAML file into a yaml.Node
+// ReadYAMLFile Reads the first document of a YAML file into a yaml.Node, and
+// ignores the rest.
+//
+// Deprecated: use ReadYAMLStream, which reads every document.
 func ReadYAMLFile(filename string) (*yaml.Node, error) {
 	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 	decoder := yaml.NewDecoder(f)
 
 	var cf yaml.Node
@@ -456,3 +905,26 @@ func ReadYAMLFile(filename string) (*yaml.Node, error) {
 	}
 	return &cf, nil
 }
+
+// ReadYAMLStream Reads every document in a YAML file into a list of yaml.Nodes
+func ReadYAMLStream(filename string) ([]*yaml.Node, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	decoder := yaml.NewDecoder(f)
+
+	docs := []*yaml.Node{}
+	for {
+		var doc yaml.Node
+		err = decoder.Decode(&doc)
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, &doc)
+	}
+}
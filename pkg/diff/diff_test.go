@@ -68,16 +68,37 @@ func TestDiffFiles(t *testing.T) {
 		takeSnapshot = true
 	}
 
-	fileSets := []string{
-		"simple/*.to.yaml",
-		"swagger/*.to.yaml",
+	fileSets := []struct {
+		pattern string
+		options []diff.Option
+	}{
+		{pattern: "simple/*.to.yaml"},
+		{pattern: "swagger/*.to.yaml"},
+		{pattern: "multidoc/*.to.yaml"},
+		{pattern: "anchors/*.to.yaml"},
+		{pattern: "resources/*.to.yaml", options: []diff.Option{diff.WithResourceMatching()}},
+		{pattern: "semantic/*.to.yaml", options: []diff.Option{diff.WithSemanticScalars()}},
+		{pattern: "identity/*.to.yaml", options: []diff.Option{
+			diff.WithIdentityKeys("spec.template.spec.containers[*]", "name"),
+			diff.WithIdentityKeys("**.parameters[*]", "name", "in"),
+		}},
+		{pattern: "similarity/*.to.yaml", options: []diff.Option{diff.WithSimilarityThreshold(0.5)}},
+		{pattern: "renames/*.to.yaml", options: []diff.Option{diff.WithRenameSimilarity(0.6)}},
+		{pattern: "comments/*.to.yaml", options: []diff.Option{diff.WithComments()}},
+		{pattern: "styles/*.to.yaml", options: []diff.Option{diff.WithStyles()}},
+		{pattern: "ignore/*.to.yaml", options: []diff.Option{
+			diff.WithIgnoredPaths("metadata.annotations.*", "status", "**.generated")}},
+		{pattern: "unordered/*.to.yaml", options: []diff.Option{
+			diff.WithUnorderedSequences("**.required[*]", "**.enum[*]", "rules[*].verbs[*]", "tags[*]")}},
+		{pattern: "lines/*.to.yaml", options: []diff.Option{diff.WithLineDiffs()}},
 		// uncomment this to test individual cases
-		// "simple/sequence-moved-item.to.yaml",
+		// {pattern: "simple/sequence-moved-item.to.yaml"},
 	}
 
-	for _, suitePath := range fileSets {
+	for _, suite := range fileSets {
+		suite := suite
 		// pattern := fixturePath("simple/*.to.yaml")
-		pattern := fixturePath(suitePath)
+		pattern := fixturePath(suite.pattern)
 		// pattern := fixturePath("simple/sequence-added-changed-nesteditem.to.yaml")
 
 		// To filter cases for debugging poke an individual case here eg "path", "enum" etc
@@ -93,7 +114,7 @@ func TestDiffFiles(t *testing.T) {
 		for _, tc := range testCases {
 			tc := tc
 			t.Run(tc.name, func(t *testing.T) {
-				changes, err := diff.GetYamlFileChanges(tc.oldSpec, tc.newSpec)
+				changes, err := diff.GetYamlFileChanges(tc.oldSpec, tc.newSpec, suite.options...)
 
 				if tc.expectedError {
 					// edge cases with error
@@ -18,11 +18,11 @@ func TestAllDiffs(t *testing.T) {
 
 func makeDiffs() error {
 	spec1Path := FixturePath("allyaml/allfields.yml")
-	spec1, err := ReadYAMLFile(spec1Path)
+	spec1, err := ReadYAMLStream(spec1Path)
 	if err != nil {
 		return err
 	}
-	hashNode := HashNode(spec1)
+	hashNode := HashNode(spec1[0])
 
 	WalkNode(hashNode, func(n *HashedNode) bool {
 		if len(n.Children) == 0 {
@@ -1,10 +1,16 @@
 package diff
 
 import (
+	"context"
 	"crypto/sha1"
-	"fmt"
+	"encoding/hex"
+	"hash"
 	"io"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"gopkg.in/yaml.v3"
 )
@@ -34,11 +40,23 @@ func (h HashedNodes) Find(key string) (int, *HashedNode) {
 
 // HashedNode wraps a node with a hash of the child nodes
 type HashedNode struct {
-	Parent   *HashedNode
-	Node     *yaml.Node
-	Key      string
+	Parent *HashedNode
+	Node   *yaml.Node
+	Key    string
+	// Hash is a hex encoded fingerprint of the kind, tag and value of the
+	// node and of the keys and hashes of its children. Equal hashes mean equal
+	// subtrees. The node's own key isn't part of its hash.
 	Hash     string
 	Children HashedNodes
+	// Anchor is the name of the nearest anchor the node was defined under or
+	// was expanded from through an alias or merge key
+	Anchor string
+	// KeyNode is the key of a mapping value, which holds most of the
+	// comments written about the value
+	KeyNode *yaml.Node
+	// contentHash is the hash without any comments or styles, which is what sequences
+	// are aligned on and scalars compared by
+	contentHash string
 }
 
 // IsScalar returns true if the Node is a scalar
@@ -46,56 +64,408 @@ func (h HashedNode) IsScalar() bool {
 	return h.Node.Kind == yaml.ScalarNode
 }
 
-//HashNode calculate hash for node and children and build HashedNode structure for comparison
-func HashNode(node *yaml.Node) *HashedNode {
-	hashedNode := HashedNode{Node: node, Children: []*HashedNode{}, Parent: nil}
+// HashNode calculate hash for node and children and build HashedNode structure for comparison.
+// Aliases and << merge keys are expanded so the hashes reflect the effective document.
+// No resource limits apply, so documents which aren't trusted should be
+// hashed with HashNodeContext.
+func HashNode(node *yaml.Node, opts ...Option) *HashedNode {
+	return hashDocument(node, "", newOptions(opts), nil)
+}
+
+// HashNodeContext hashes a node like HashNode, within the resource limits in
+// the options. It gives up with the context's error when the context is
+// done, or with a *LimitError when one of the limits is exceeded.
+func HashNodeContext(ctx context.Context, node *yaml.Node, opts ...Option) (*HashedNode, error) {
+	options := newOptions(opts)
+	limitedCtx, cancel := withTimeLimit(ctx, options)
+	defer cancel()
+	g := newGuard(limitedCtx, ctx, options)
+
+	hashed := hashDocument(node, "", options, g)
+	if g.err != nil {
+		return nil, g.err
+	}
+	return hashed, nil
+}
+
+// hashDocument hashes a document, keyed by its index in a stream, within the
+// limits of the guard
+func hashDocument(node *yaml.Node, docKey string, options Options, g *guard) *HashedNode {
+	h := hasher{options: options, expanding: map[*yaml.Node]bool{}, guard: g, docKey: docKey}
+	workers := options.Workers
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > 1 {
+		// the calling goroutine is one of the workers
+		h.workers = make(chan struct{}, workers-1)
+	}
+	hashed := h.hashNode(node, nil, "")
+	hashed.Key = docKey
+	return hashed
+}
+
+// hasher tracks the state needed while hashing a single document
+type hasher struct {
+	options Options
+	// anchored nodes currently being hashed, used to stop recursive aliases
+	expanding map[*yaml.Node]bool
+	// segments of the path from the root down to the node being hashed
+	segments []PathSegment
+	// guard enforces the resource limits, when there are any
+	guard *guard
+	// docKey is the index of the document in a multi-document stream
+	docKey string
+	// workers holds a token for each extra goroutine hashing the document.
+	// It's nil when hashing serially.
+	workers chan struct{}
+}
+
+// minParallelChildren is the fewest children a node needs before they are
+// hashed in parallel, below which the goroutines cost more than they save
+const minParallelChildren = 8
+
+// fork copies the hasher for hashing a subtree on another goroutine
+func (h *hasher) fork() *hasher {
+	expanding := make(map[*yaml.Node]bool, len(h.expanding))
+	for node := range h.expanding {
+		expanding[node] = true
+	}
+	segments := make([]PathSegment, len(h.segments), len(h.segments)+8)
+	copy(segments, h.segments)
+	return &hasher{
+		options:   h.options,
+		expanding: expanding,
+		segments:  segments,
+		guard:     h.guard,
+		docKey:    h.docKey,
+		workers:   h.workers,
+	}
+}
+
+// path renders the path of the node being hashed
+func (h *hasher) path() string {
+	if len(h.segments) < 2 {
+		return keyPath(h.docKey, nil)
+	}
+	return keyPath(h.docKey, h.segments[1:])
+}
+
+func (h *hasher) hashNode(node, keyNode *yaml.Node, anchor string) *HashedNode {
+	if !h.guard.enterNode(len(h.segments)-1, h.path) {
+		// the diff is being abandoned so the hash doesn't matter
+		return &HashedNode{Node: node, KeyNode: keyNode, Children: HashedNodes{}}
+	}
+	if node.Kind == yaml.AliasNode && node.Alias != nil && !h.expanding[node.Alias] {
+		if !h.guard.expandAlias(h.path) {
+			return &HashedNode{Node: node, KeyNode: keyNode, Children: HashedNodes{}}
+		}
+		return h.hashNode(node.Alias, keyNode, node.Alias.Anchor)
+	}
+	if node.Anchor != "" {
+		anchor = node.Anchor
+		h.expanding[node] = true
+		defer delete(h.expanding, node)
+	}
+	hashedNode := HashedNode{Node: node, KeyNode: keyNode, Children: []*HashedNode{}, Parent: nil, Anchor: anchor}
 
 	switch node.Kind {
 	case yaml.DocumentNode:
-		buildChildren(&hashedNode)
-		hashChildren(&hashedNode)
+		h.buildChildren(&hashedNode)
+		h.hashChildren(&hashedNode)
 	case yaml.SequenceNode:
-		buildChildren(&hashedNode)
-		hashChildren(&hashedNode)
+		h.buildChildren(&hashedNode)
+		h.hashChildren(&hashedNode)
 	case yaml.MappingNode:
-		buildChildren(&hashedNode)
-		hashChildren(&hashedNode)
+		h.buildMappedChildren(&hashedNode)
+		h.hashMappedChildren(&hashedNode)
 	case yaml.ScalarNode:
-		h := sha1.New()
-		hashedNode.Hash = string(h.Sum([]byte(node.Value)))
+		h.hashScalar(&hashedNode)
 	case yaml.AliasNode:
-		// don't handle these yet
+		// only recursive aliases get here, so compare them by name
+		sha := newNodeHash(node)
+		writeField(sha, node.Value)
+		h.setHashes(&hashedNode, sha, sha)
 	}
 	return &hashedNode
 }
 
-func hashChildren(hashedNode *HashedNode) {
-	h := sha1.New()
+func (h *hasher) hashScalar(hashedNode *HashedNode) {
+	node := hashedNode.Node
+	var sha hash.Hash
+	if h.options.SemanticScalars {
+		tag, value := canonicalScalar(node)
+		sha = getHash()
+		writeField(sha, KindLabels[node.Kind])
+		writeField(sha, tag)
+		writeField(sha, value)
+	} else {
+		sha = newNodeHash(node)
+		writeField(sha, node.Value)
+	}
+	h.setHashes(hashedNode, sha, sha)
+}
+
+// setHashes sets the content hash of a node and its full hash, which also
+// covers the node's comments and style when they are being compared
+func (h *hasher) setHashes(hashedNode *HashedNode, content, full hash.Hash) {
+	hashedNode.contentHash = sumHash(content)
+	if !h.decorated() {
+		hashedNode.Hash = hashedNode.contentHash
+		return
+	}
+	if full == content {
+		full = getHash()
+		writeField(full, hashedNode.contentHash)
+	}
+	if h.options.Comments {
+		writeField(full, commentText(hashedNode))
+	}
+	if h.options.Styles {
+		writeField(full, styleLabel(hashedNode.Node))
+	}
+	hashedNode.Hash = sumHash(full)
+}
+
+// decorated reports whether the full hashes cover more than the content
+func (h *hasher) decorated() bool {
+	return h.options.Comments || h.options.Styles
+}
+
+// shaPool reuses the sha1 state between nodes
+var shaPool = sync.Pool{New: func() interface{} { return sha1.New() }}
+
+func getHash() hash.Hash {
+	sha := shaPool.Get().(hash.Hash)
+	sha.Reset()
+	return sha
+}
+
+// sumHash returns the hex encoded sum and releases the hash back to the pool
+func sumHash(sha hash.Hash) string {
+	var sum [sha1.Size]byte
+	encoded := hex.EncodeToString(sha.Sum(sum[:0]))
+	shaPool.Put(sha)
+	return encoded
+}
+
+// newNodeHash starts a hash with the kind and tag of the node
+func newNodeHash(node *yaml.Node) hash.Hash {
+	sha := getHash()
+	writeField(sha, KindLabels[node.Kind])
+	writeField(sha, node.ShortTag())
+	return sha
+}
+
+// writeField writes a length prefixed value so adjacent fields can't run
+// into each other
+func writeField(w io.Writer, value string) {
+	var prefix [24]byte
+	w.Write(append(strconv.AppendInt(prefix[:0], int64(len(value)), 10), ':'))
+	io.WriteString(w, value)
+}
+
+// hashChildren hashes the children in order
+func (h *hasher) hashChildren(hashedNode *HashedNode) {
+	content := newNodeHash(hashedNode.Node)
+	full := content
+	if h.decorated() {
+		full = newNodeHash(hashedNode.Node)
+	}
 	for _, eachChild := range hashedNode.Children {
-		io.WriteString(h, string(eachChild.Hash))
+		writeField(content, eachChild.contentHash)
+		if full != content {
+			writeField(full, eachChild.Hash)
+		}
 	}
-	hashedNode.Hash = string(h.Sum(nil))
+	h.setHashes(hashedNode, content, full)
 }
 
-func buildChildren(hashedNode *HashedNode) {
-	var previousKeyNode string
-	nodeKind := hashedNode.Node.Kind
+// hashMappedChildren hashes each key with its value. The order of the keys
+// in a mapping isn't significant so neither is the order of the pairs.
+func (h *hasher) hashMappedChildren(hashedNode *HashedNode) {
+	content := newNodeHash(hashedNode.Node)
+	for _, pair := range sortedPairs(hashedNode.Children, func(child *HashedNode) string { return child.contentHash }) {
+		writeField(content, pair)
+	}
+	full := content
+	if h.decorated() {
+		full = newNodeHash(hashedNode.Node)
+		for _, pair := range sortedPairs(hashedNode.Children, func(child *HashedNode) string { return child.Hash }) {
+			writeField(full, pair)
+		}
+	}
+	h.setHashes(hashedNode, content, full)
+}
+
+// sortedPairs hashes each child's key with one of its hashes and sorts them
+func sortedPairs(children HashedNodes, hashOf func(*HashedNode) string) []string {
+	pairs := make([]string, len(children))
+	for ind, eachChild := range children {
+		pairs[ind] = hashPair(eachChild.Key, hashOf(eachChild))
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+func hashPair(key, valueHash string) string {
+	pair := getHash()
+	writeField(pair, key)
+	writeField(pair, valueHash)
+	var sum [sha1.Size]byte
+	hashed := string(pair.Sum(sum[:0]))
+	shaPool.Put(pair)
+	return hashed
+}
+
+// childNode is a child waiting to be hashed
+type childNode struct {
+	key     string
+	segment PathSegment
+	keyNode *yaml.Node
+	node    *yaml.Node
+	anchor  string
+}
+
+func (h *hasher) buildChildren(hashedNode *HashedNode) {
+	children := make([]childNode, len(hashedNode.Node.Content))
 	for ind, eachChild := range hashedNode.Node.Content {
-		key := fmt.Sprintf("[%d]", ind)
+		children[ind] = childNode{key: "[" + strconv.Itoa(ind) + "]", segment: IndexSegment(ind), node: eachChild, anchor: hashedNode.Anchor}
+	}
+	h.addChildren(hashedNode, children)
+}
 
-		if nodeKind == yaml.MappingNode {
-			if ind%2 == 0 {
-				previousKeyNode = eachChild.Value
+// addChildren hashes the children and adds them to the node in order. The
+// children of large nodes are hashed on other goroutines while there are
+// workers free, and on this one otherwise.
+func (h *hasher) addChildren(hashedNode *HashedNode, children []childNode) {
+	hashed := make(HashedNodes, len(children))
+	parallel := h.workers != nil && len(children) >= minParallelChildren
+	var wg sync.WaitGroup
+	for ind, child := range children {
+		if parallel && child.node.Kind != yaml.ScalarNode {
+			select {
+			case h.workers <- struct{}{}:
+				wg.Add(1)
+				go func(ind int, child childNode, forked *hasher) {
+					defer func() {
+						<-h.workers
+						wg.Done()
+					}()
+					hashed[ind] = forked.hashChild(child)
+				}(ind, child, h.fork())
 				continue
-			} else {
-				key = previousKeyNode
+			default:
 			}
 		}
-		childHashedNode := HashNode(eachChild)
-		childHashedNode.Key = key
-		hashedNode.Children = append(hashedNode.Children, childHashedNode)
-		childHashedNode.Parent = hashedNode
+		hashed[ind] = h.hashChild(child)
+	}
+	wg.Wait()
+
+	hashedNode.Children = make(HashedNodes, 0, len(hashed))
+	for _, child := range hashed {
+		// ignored children are left out
+		if child != nil {
+			child.Parent = hashedNode
+			hashedNode.Children = append(hashedNode.Children, child)
+		}
+	}
+}
+
+func (h *hasher) hashChild(child childNode) *HashedNode {
+	h.segments = append(h.segments, child.segment)
+	defer func() { h.segments = h.segments[:len(h.segments)-1] }()
+	if h.ignored() {
+		return nil
+	}
+	hashed := h.hashNode(child.node, child.keyNode, child.anchor)
+	hashed.Key = child.key
+	return hashed
+}
+
+// ignored reports whether the node being added matches an ignore rule. Ignored
+// nodes are left out of the tree altogether, so they are neither hashed nor
+// compared. The first key is the root's own, which isn't part of any path.
+func (h *hasher) ignored() bool {
+	if len(h.segments) < 2 {
+		return false
+	}
+	for _, pattern := range h.options.ignorePaths {
+		if matchSegments(pattern, h.segments[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// mappedValue is a key and value pair from a mapping node, along with the
+// anchor it was merged from
+type mappedValue struct {
+	key    *yaml.Node
+	value  *yaml.Node
+	anchor string
+}
+
+// buildMappedChildren adds the explicit keys of a mapping followed by any
+// keys merged in with << which the mapping doesn't override
+func (h *hasher) buildMappedChildren(hashedNode *HashedNode) {
+	values := h.mappedValues(hashedNode.Node, hashedNode.Anchor)
+	children := make([]childNode, len(values))
+	for ind, each := range values {
+		children[ind] = childNode{key: each.key.Value, segment: KeySegment(each.key.Value), keyNode: each.key, node: each.value, anchor: each.anchor}
+	}
+	h.addChildren(hashedNode, children)
+}
+
+func (h *hasher) mappedValues(mapping *yaml.Node, anchor string) []mappedValue {
+	values := []mappedValue{}
+	merged := []mappedValue{}
+	seen := map[string]bool{}
+	for ind := 0; ind+1 < len(mapping.Content); ind += 2 {
+		keyNode, valueNode := mapping.Content[ind], mapping.Content[ind+1]
+		if keyNode.Kind == yaml.ScalarNode && keyNode.ShortTag() == "!!merge" {
+			merged = append(merged, h.mergedValues(valueNode)...)
+			continue
+		}
+		seen[keyNode.Value] = true
+		values = append(values, mappedValue{keyNode, valueNode, anchor})
+	}
+	// explicit keys override merged ones, and earlier merges override later ones
+	for _, each := range merged {
+		if !seen[each.key.Value] {
+			seen[each.key.Value] = true
+			values = append(values, each)
+		}
 	}
+	return values
+}
+
+// mergedValues returns the key and value pairs of the mappings referred to
+// by a << merge key, which may be a single mapping or a sequence of them
+func (h *hasher) mergedValues(node *yaml.Node) []mappedValue {
+	anchor := ""
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		if h.expanding[node.Alias] || !h.guard.expandAlias(h.path) {
+			return nil
+		}
+		node = node.Alias
+		anchor = node.Anchor
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		if anchor != "" {
+			h.expanding[node] = true
+			defer delete(h.expanding, node)
+		}
+		return h.mappedValues(node, anchor)
+	case yaml.SequenceNode:
+		values := []mappedValue{}
+		for _, each := range node.Content {
+			values = append(values, h.mergedValues(each)...)
+		}
+		return values
+	}
+	return nil
 }
 
 // GetPath returns the nodes up to the root
@@ -108,7 +478,13 @@ func (h *HashedNode) GetPath() HashedNodes {
 
 func (h HashedNodes) String() string {
 	bld := strings.Builder{}
-	bld.WriteString("doc.")
+	bld.WriteString("doc")
+	if len(h) > 0 {
+		// the grandparent of the first path element is the document node,
+		// which is keyed by its index in multi-document streams
+		bld.WriteString(h[0].Parent.Parent.Key)
+	}
+	bld.WriteString(".")
 	finalDotIndex := len(h) - 1
 	for ind, eachPart := range h {
 		if ind <= finalDotIndex && ind > 0 {
@@ -0,0 +1,122 @@
+package diff_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+// largeSpec generates an openapi style document with the given number of paths
+func largeSpec(paths int) string {
+	bld := strings.Builder{}
+	bld.WriteString("swagger: \"2.0\"\npaths:\n")
+	for ind := 0; ind < paths; ind++ {
+		fmt.Fprintf(&bld, `  /things/%d:
+    get:
+      summary: Get thing %d
+      operationId: getThing%d
+      parameters:
+        - name: id
+          in: path
+          required: true
+          type: integer
+        - name: verbose
+          in: query
+          type: boolean
+      responses:
+        200:
+          description: The thing
+          schema:
+            type: object
+            required: [id, name]
+            properties:
+              id: {type: integer, format: int64}
+              name: {type: string}
+              tags: {type: array, items: {type: string}}
+`, ind, ind, ind)
+	}
+	return bld.String()
+}
+
+func parseYaml(b *testing.B, src []byte) *yaml.Node {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		b.Fatal(err)
+	}
+	return &doc
+}
+
+func benchmarkHashing(b *testing.B, doc *yaml.Node) {
+	for _, workers := range []int{1, runtime.GOMAXPROCS(0) * 2} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			for ind := 0; ind < b.N; ind++ {
+				diff.HashNode(doc, diff.WithWorkers(workers))
+			}
+		})
+	}
+}
+
+func BenchmarkHashSwagger(b *testing.B) {
+	files, err := filepath.Glob(fixturePath("swagger/*.from.yaml"))
+	if err != nil || len(files) == 0 {
+		b.Fatal("no swagger fixtures", err)
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(filepath.Base(file), func(b *testing.B) {
+			benchmarkHashing(b, parseYaml(b, src))
+		})
+	}
+}
+
+func BenchmarkHashLargeDocument(b *testing.B) {
+	benchmarkHashing(b, parseYaml(b, []byte(largeSpec(2000))))
+}
+
+// editedSpec is a large spec with some paths added and some values changed
+func editedSpec(paths int) string {
+	return strings.Replace(largeSpec(paths+paths/40), "format: int64", "format: int32", paths/10)
+}
+
+func benchmarkDiff(b *testing.B, diffDocs func(opts ...diff.Option) (diff.ChangeLogEntries, error)) {
+	for _, workers := range []int{1, runtime.GOMAXPROCS(0) * 2} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			for ind := 0; ind < b.N; ind++ {
+				if _, err := diffDocs(diff.WithWorkers(workers)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDiffLargeDocument(b *testing.B) {
+	doc1 := parseYaml(b, []byte(largeSpec(2000)))
+	doc2 := parseYaml(b, []byte(editedSpec(2000)))
+	benchmarkDiff(b, func(opts ...diff.Option) (diff.ChangeLogEntries, error) {
+		return diff.GetYamlNodeChanges(doc1, doc2, opts...)
+	})
+}
+
+func BenchmarkDiffLargeStream(b *testing.B) {
+	docs1 := make([]*yaml.Node, 200)
+	docs2 := make([]*yaml.Node, len(docs1))
+	for ind := range docs1 {
+		docs1[ind] = parseYaml(b, []byte(largeSpec(10)))
+		docs2[ind] = parseYaml(b, []byte(editedSpec(10)))
+	}
+	benchmarkDiff(b, func(opts ...diff.Option) (diff.ChangeLogEntries, error) {
+		return diff.GetYamlStreamChanges(docs1, docs2, opts...)
+	})
+}
@@ -37,3 +37,34 @@ func TestHash(t *testing.T) {
 	node = node.Children[0]
 	assertThat(t, node, not(is.Nil()))
 }
+
+func TestHashCoversKeysTagsAndKind(t *testing.T) {
+	hashOf := func(src string) string {
+		var doc yaml.Node
+		err := yaml.Unmarshal([]byte(src), &doc)
+		assertThat(t, err, is.Nil())
+		return diff.HashNode(&doc).Hash
+	}
+
+	assertThat(t, hashOf("{a: 1}"), not(is.EqualTo(hashOf("{b: 1}"))))
+	assertThat(t, hashOf("port: 8080"), not(is.EqualTo(hashOf(`port: "8080"`))))
+	assertThat(t, hashOf("[a]"), not(is.EqualTo(hashOf("a"))))
+	assertThat(t, hashOf("{a: 1, b: 2}"), is.EqualTo(hashOf("{b: 2, a: 1}")))
+
+	// the hash is a stable hex fingerprint
+	assertThat(t, hashOf("a: 1"), is.EqualTo("0fcf12f927a7c4e041e97c4e2ff6ceca86deeada"))
+}
+
+func TestParallelHashMatchesSerial(t *testing.T) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(largeSpec(200)), &doc); err != nil {
+		t.Fatal(err)
+	}
+	serial := diff.HashNode(&doc, diff.WithWorkers(1), diff.WithComments())
+	parallel := diff.HashNode(&doc, diff.WithWorkers(8), diff.WithComments())
+	assertThat(t, parallel.Hash, is.EqualTo(serial.Hash))
+	paths := parallel.Children[0].Children[1].Children
+	assertThat(t, len(paths), is.EqualTo(200))
+	assertThat(t, paths[199].Key, is.EqualTo("/things/199"))
+	assertThat(t, paths[199].Parent, is.EqualTo(parallel.Children[0].Children[1]))
+}
@@ -0,0 +1,176 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wjase/diffyaml/pkg/array"
+	"gopkg.in/yaml.v3"
+)
+
+// identityRule says which fields identify the items of the sequences
+// matching a path pattern
+type identityRule struct {
+	pattern pathPattern
+	fields  []string
+}
+
+// identityPairs pairs up the items of two lists which share an identity
+type identityPairs struct {
+	// aligned pairs kept their order relative to each other
+	aligned [][2]int
+	// moved pairs changed their position relative to the other items
+	moved   [][2]int
+	deleted []int
+	added   []int
+}
+
+// pairByIdentity pairs the items in two lists of identities. Only items which
+// changed order relative to the others are moved, so inserting an item at the
+// top of a list doesn't move everything after it.
+func (d *differ) pairByIdentity(ids1, ids2 []string, path func() string) identityPairs {
+	diffs := d.diffStrings(ids1, ids2, path)
+	pairs := identityPairs{aligned: alignedPairs(len(ids1), len(ids2), diffs)}
+
+	deleted := map[int]bool{}
+	added := map[int]bool{}
+	for _, item := range diffs {
+		if item.Code == array.DeleteItem {
+			deleted[item.FromIndex] = true
+		}
+		if item.Code == array.AddItem {
+			added[item.ToIndex] = true
+		}
+	}
+
+	// an item deleted in one place and added in another has moved
+	addedByID := map[string][]int{}
+	for index2 := range ids2 {
+		if added[index2] {
+			addedByID[ids2[index2]] = append(addedByID[ids2[index2]], index2)
+		}
+	}
+	for index1 := range ids1 {
+		if !deleted[index1] {
+			continue
+		}
+		candidates := addedByID[ids1[index1]]
+		if len(candidates) == 0 {
+			pairs.deleted = append(pairs.deleted, index1)
+			continue
+		}
+		addedByID[ids1[index1]] = candidates[1:]
+		delete(added, candidates[0])
+		pairs.moved = append(pairs.moved, [2]int{index1, candidates[0]})
+	}
+
+	for index2 := range ids2 {
+		if added[index2] {
+			pairs.added = append(pairs.added, index2)
+		}
+	}
+	return pairs
+}
+
+// identityFields returns the identity fields configured for the items of a
+// sequence, or nil when the items are matched by position
+func (d *differ) identityFields(seq1, seq2 HashedNodes) []string {
+	for _, rule := range d.options.identityKeys {
+		if rule.pattern.matchesSequence(seq1, seq2) {
+			return rule.fields
+		}
+	}
+	return nil
+}
+
+// diffSequenceByIdentity matches the items of two sequences by the values of
+// their identity fields, eg the name of a container
+func (d *differ) diffSequenceByIdentity(seq1, seq2 HashedNodes, fields []string) ChangeLogEntries {
+	pairs := d.pairByIdentity(itemIdentities(seq1, fields), itemIdentities(seq2, fields), itemsPath(seq1))
+	changes := ChangeLogEntries{}
+
+	for _, pair := range pairs.aligned {
+		item1, item2 := seq1[pair[0]], seq2[pair[1]]
+		if item1.Hash != item2.Hash {
+			changes = append(changes, d.diffNode(item1, item2)...)
+		}
+	}
+	for _, pair := range pairs.moved {
+		fromIndex, toIndex := pair[0], pair[1]
+		item1, item2 := seq1[fromIndex], seq2[toIndex]
+		changes = append(changes, ChangeLogEntry{
+			Path:       item1.Path(),
+			ChangeType: Moved,
+			FromIndex:  &fromIndex,
+			ToIndex:    &toIndex,
+			From:       item1.Node,
+			To:         item2.Node,
+			Line:       &item2.Node.Line,
+			Column:     &item2.Node.Column,
+			Anchor:     anchorOf(item2, item1),
+		})
+		if item1.Hash != item2.Hash {
+			changes = append(changes, d.diffNode(item1, item2)...)
+		}
+	}
+	for _, index := range pairs.deleted {
+		deletedIndex, item := index, seq1[index]
+		changes = append(changes, ChangeLogEntry{
+			Path:       item.Path(),
+			ChangeType: Deleted,
+			FromIndex:  &deletedIndex,
+			From:       item.Node,
+			Line:       &item.Node.Line,
+			Column:     &item.Node.Column,
+			Anchor:     item.Anchor,
+		})
+	}
+	for _, index := range pairs.added {
+		addedIndex, item := index, seq2[index]
+		changes = append(changes, ChangeLogEntry{
+			Path:       item.Path(),
+			ChangeType: Added,
+			ToIndex:    &addedIndex,
+			To:         item.Node,
+			Line:       &item.Node.Line,
+			Column:     &item.Node.Column,
+			Anchor:     item.Anchor,
+		})
+	}
+	return changes
+}
+
+// itemIdentities builds an identity for each item from its identity fields.
+// Items missing any of the fields fall back to their position, and repeated
+// identities are numbered so each one is distinct.
+func itemIdentities(items HashedNodes, fields []string) []string {
+	ids := make([]string, len(items))
+	seen := map[string]int{}
+	for index, item := range items {
+		id, ok := itemIdentity(item, fields)
+		if !ok {
+			id = fmt.Sprintf("[%d]", index)
+		}
+		seen[id]++
+		if seen[id] > 1 {
+			id = fmt.Sprintf("%s#%d", id, seen[id])
+		}
+		ids[index] = id
+	}
+	return ids
+}
+
+func itemIdentity(item *HashedNode, fields []string) (string, bool) {
+	if item.Node.Kind != yaml.MappingNode {
+		return "", false
+	}
+	values := make([]string, len(fields))
+	for index, field := range fields {
+		child := findChild(item, field)
+		if child == nil || !child.IsScalar() {
+			return "", false
+		}
+		values[index] = field + "=" + child.Node.Value
+	}
+	return strings.Join(values, ","), true
+}
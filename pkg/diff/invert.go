@@ -0,0 +1,309 @@
+package diff
+
+// Invert returns the changes which turn the new document back into the old
+// one. Deletes, moves and the other changes are reported under the paths of
+// the documents they apply to, so the paths are converted between the old and
+// new documents.
+func (l ChangeLogEntries) Invert() ChangeLogEntries {
+	paths := l.PathMap()
+	inverted := make(ChangeLogEntries, 0, len(l))
+	for _, change := range l {
+		if change.ChangeType == NoChange {
+			continue
+		}
+		inverse := change
+		inverse.From, inverse.To = change.To, change.From
+		inverse.FromIndex, inverse.ToIndex = change.ToIndex, change.FromIndex
+		inverse.FromKind, inverse.ToKind = change.ToKind, change.FromKind
+		inverse.FromTag, inverse.ToTag = change.ToTag, change.FromTag
+		inverse.FromKey, inverse.ToKey = change.ToKey, change.FromKey
+		inverse.FromComment, inverse.ToComment = change.ToComment, change.FromComment
+		inverse.FromStyle, inverse.ToStyle = change.ToStyle, change.FromStyle
+		inverse.FromCount, inverse.ToCount = change.ToCount, change.FromCount
+		if change.LineDiff != nil {
+			inverse.LineDiff = make([]LineChange, len(change.LineDiff))
+			for ind, line := range change.LineDiff {
+				inverse.LineDiff[ind] = LineChange{ChangeType: invertedType(line.ChangeType), Offset: line.Offset, Text: line.Text}
+			}
+		}
+		inverse.ChangeType = invertedType(change.ChangeType)
+
+		switch change.ChangeType {
+		case Added, Deleted:
+			// an added node's path in the new document is where the inverse
+			// deletes it from, and the other way around
+		case Moved:
+			// moves are reported under the path the item moved from
+			if path, ok := paths.ToNew(change.Path); ok {
+				inverse.Path = path
+			}
+		default:
+			// the other changes are reported under the new document's path
+			if path, ok := paths.ToOld(change.Path); ok {
+				inverse.Path = path
+			}
+		}
+
+		located := inverse.To
+		if inverse.ChangeType == Deleted || inverse.ChangeType == Moved {
+			located = inverse.From
+		}
+		if located != nil {
+			inverse.Line = &located.Line
+			inverse.Column = &located.Column
+		}
+		inverted = append(inverted, inverse)
+	}
+	return inverted
+}
+
+func invertedType(changeType ChangeType) ChangeType {
+	switch changeType {
+	case Added:
+		return Deleted
+	case Deleted:
+		return Added
+	}
+	return changeType
+}
+
+// PathMap converts paths between the old and new documents of a changelog, by
+// following the items added, deleted and moved in each sequence and the keys
+// renamed in each mapping
+type PathMap struct {
+	// sequences holds the edits of each sequence, keyed by its old path
+	sequences map[string]*sequenceEdits
+	// added holds the indexes added to each sequence, keyed by its new path
+	added map[string]map[int]bool
+	// deletedPaths holds the paths of the deleted nodes in the old document
+	deletedPaths map[string]bool
+	// addedPaths holds the paths of the added nodes in the new document
+	addedPaths map[string]bool
+	// renames holds the renamed keys of each mapping, keyed by its new path
+	renames map[string]map[string]string
+}
+
+// sequenceEdits are the items deleted from and moved within a sequence
+type sequenceEdits struct {
+	deleted map[int]bool
+	// moved maps the old index of each moved item to its new one
+	moved map[int]int
+}
+
+// streamKey stands for the stream of documents when a change's path is a
+// whole document
+const streamKey = "stream"
+
+// PathMap returns the PathMap of the changes
+func (l ChangeLogEntries) PathMap() *PathMap {
+	paths := &PathMap{
+		sequences:    map[string]*sequenceEdits{},
+		added:        map[string]map[int]bool{},
+		deletedPaths: map[string]bool{},
+		addedPaths:   map[string]bool{},
+		renames:      map[string]map[string]string{},
+	}
+	for _, change := range l {
+		parent, index, isIndex := parentKey(change.Path)
+		switch change.ChangeType {
+		case Deleted:
+			paths.deletedPaths[change.Path.Quoted()] = true
+			if isIndex {
+				paths.edits(parent).deleted[index] = true
+			}
+		case Moved:
+			if isIndex && change.ToIndex != nil {
+				paths.edits(parent).moved[index] = *change.ToIndex
+			}
+		case Added:
+			paths.addedPaths[change.Path.Quoted()] = true
+			if isIndex {
+				if paths.added[parent] == nil {
+					paths.added[parent] = map[int]bool{}
+				}
+				paths.added[parent][index] = true
+			}
+		case Renamed:
+			if paths.renames[parent] == nil {
+				paths.renames[parent] = map[string]string{}
+			}
+			paths.renames[parent][change.FromKey] = change.ToKey
+		}
+	}
+	return paths
+}
+
+func (m *PathMap) edits(parent string) *sequenceEdits {
+	if m.sequences[parent] == nil {
+		m.sequences[parent] = &sequenceEdits{deleted: map[int]bool{}, moved: map[int]int{}}
+	}
+	return m.sequences[parent]
+}
+
+// parentKey returns the key of the parent of a path, and the path's index in
+// its parent when the parent is a sequence or the stream of documents
+func parentKey(path Path) (string, int, bool) {
+	last, ok := path.Last()
+	if !ok {
+		if path.Document == nil {
+			return "", 0, false
+		}
+		return streamKey, *path.Document, true
+	}
+	return path.Parent().Quoted(), last.Index, last.IsIndex
+}
+
+// ToNew converts a path in the old document to the path of the same node in
+// the new one. It reports false when the node was deleted.
+func (m *PathMap) ToNew(old Path) (Path, bool) {
+	newPath := Path{}
+	if old.Document != nil {
+		index, ok := m.newIndex(streamKey, streamKey, *old.Document)
+		if !ok {
+			return old, false
+		}
+		newPath.Document = &index
+	}
+	oldPrefix := Path{Document: old.Document}
+	for _, segment := range old.Segments {
+		oldChild := oldPrefix.Child(segment)
+		if m.deletedPaths[oldChild.Quoted()] {
+			return old, false
+		}
+		if segment.IsIndex {
+			index, ok := m.newIndex(oldPrefix.Quoted(), newPath.Quoted(), segment.Index)
+			if !ok {
+				return old, false
+			}
+			segment = IndexSegment(index)
+		} else if renamed, ok := m.renames[newPath.Quoted()][segment.Key]; ok {
+			segment = KeySegment(renamed)
+		}
+		oldPrefix = oldChild
+		newPath = newPath.Child(segment)
+	}
+	return newPath, true
+}
+
+// ToOld converts a path in the new document to the path of the same node in
+// the old one. It reports false when the node was added.
+func (m *PathMap) ToOld(new Path) (Path, bool) {
+	oldPath := Path{}
+	if new.Document != nil {
+		index, ok := m.oldIndex(streamKey, streamKey, *new.Document)
+		if !ok {
+			return new, false
+		}
+		oldPath.Document = &index
+	}
+	newPrefix := Path{Document: new.Document}
+	for _, segment := range new.Segments {
+		newChild := newPrefix.Child(segment)
+		if m.addedPaths[newChild.Quoted()] {
+			return new, false
+		}
+		if segment.IsIndex {
+			index, ok := m.oldIndex(oldPath.Quoted(), newPrefix.Quoted(), segment.Index)
+			if !ok {
+				return new, false
+			}
+			segment = IndexSegment(index)
+		} else {
+			for from, to := range m.renames[newPrefix.Quoted()] {
+				if to == segment.Key {
+					segment = KeySegment(from)
+					break
+				}
+			}
+		}
+		newPrefix = newChild
+		oldPath = oldPath.Child(segment)
+	}
+	return oldPath, true
+}
+
+// newIndex converts the index of an item in the old sequence to its index in
+// the new one. The items which weren't deleted, moved or added keep their
+// order, so the nth of them in the old sequence is the nth in the new one.
+func (m *PathMap) newIndex(oldParent, newParent string, index int) (int, bool) {
+	edits := m.sequences[oldParent]
+	added := m.added[newParent]
+	if edits == nil && added == nil {
+		return index, true
+	}
+	if edits == nil {
+		edits = &sequenceEdits{}
+	}
+	if edits.deleted[index] {
+		return 0, false
+	}
+	if to, ok := edits.moved[index]; ok {
+		return to, true
+	}
+	kept := index
+	for removed := range edits.deleted {
+		if removed < index {
+			kept--
+		}
+	}
+	for removed := range edits.moved {
+		if removed < index {
+			kept--
+		}
+	}
+	inserted := map[int]bool{}
+	for to := range added {
+		inserted[to] = true
+	}
+	for _, to := range edits.moved {
+		inserted[to] = true
+	}
+	return nthFree(inserted, kept), true
+}
+
+// oldIndex converts the index of an item in the new sequence to its index in
+// the old one, like newIndex the other way around
+func (m *PathMap) oldIndex(oldParent, newParent string, index int) (int, bool) {
+	edits := m.sequences[oldParent]
+	added := m.added[newParent]
+	if edits == nil && added == nil {
+		return index, true
+	}
+	if edits == nil {
+		edits = &sequenceEdits{}
+	}
+	if added[index] {
+		return 0, false
+	}
+	removed := map[int]bool{}
+	for from := range edits.deleted {
+		removed[from] = true
+	}
+	kept := index
+	for to := range added {
+		if to < index {
+			kept--
+		}
+	}
+	for from, to := range edits.moved {
+		if to == index {
+			return from, true
+		}
+		if to < index {
+			kept--
+		}
+		removed[from] = true
+	}
+	return nthFree(removed, kept), true
+}
+
+// nthFree returns the nth index which isn't taken
+func nthFree(taken map[int]bool, n int) int {
+	index := 0
+	for ; taken[index] || n > 0; index++ {
+		if !taken[index] {
+			n--
+		}
+	}
+	return index
+}
@@ -0,0 +1,41 @@
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/corbym/gocrest/is"
+	"github.com/stretchr/testify/require"
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+func TestInvertSwapsSidesAndPaths(t *testing.T) {
+	var old, new yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte("list: [a, b, c]\nservers:\n  web: {host: h, tls: true, port: 1}\n"), &old))
+	require.NoError(t, yaml.Unmarshal([]byte("list: [c, a, d]\nservers:\n  api: {host: h, tls: true, port: 2}\n"), &new))
+
+	changes, err := diff.GetYamlNodeChanges(&old, &new, diff.WithRenameSimilarity(0.5))
+	require.NoError(t, err)
+
+	inverted := changes.Invert()
+	summary := make([]string, len(inverted))
+	for ind, change := range inverted {
+		summary[ind] = change.ChangeType.String() + " " + change.Path.String()
+	}
+	assertThat(t, summary, is.EqualTo([]string{
+		"added doc.list.[1]",
+		"moved doc.list.[1]",
+		"deleted doc.list.[2]",
+		"renamed doc.servers.web",
+		"changed doc.servers.web.port",
+	}))
+
+	// the move goes back to where it came from
+	assertThat(t, *inverted[1].FromIndex, is.EqualTo(1))
+	assertThat(t, *inverted[1].ToIndex, is.EqualTo(0))
+	assertThat(t, inverted[0].To.Value, is.EqualTo("b"))
+	assertThat(t, inverted[3].FromKey, is.EqualTo("api"))
+	assertThat(t, inverted[3].ToKey, is.EqualTo("web"))
+	assertThat(t, inverted[4].From.Value, is.EqualTo("2"))
+	assertThat(t, inverted[4].To.Value, is.EqualTo("1"))
+}
@@ -0,0 +1,142 @@
+package diff
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Limit names one of the resource limits on a diff
+type Limit string
+
+const (
+	// NodeLimit caps the number of nodes hashed, counting expanded aliases
+	NodeLimit Limit = "nodes"
+	// DepthLimit caps how deeply nodes may be nested
+	DepthLimit Limit = "depth"
+	// AliasLimit caps the number of aliases and merge keys expanded
+	AliasLimit Limit = "aliases"
+	// TimeLimit caps how long a diff may run
+	TimeLimit Limit = "time"
+)
+
+// LimitError is returned when a diff exceeds one of its limits
+type LimitError struct {
+	Limit Limit
+	// Path is where the limit was exceeded
+	Path string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("diff exceeded the %s limit at %s", e.Limit, e.Path)
+}
+
+// guard enforces the limits and cancellation of a diff. A nil guard enforces
+// nothing. It's shared by the goroutines hashing a document, and is checked
+// for every node, so it only takes its lock once a limit trips.
+type guard struct {
+	// nodes and aliases are counted atomically, and come first to keep them
+	// aligned on 32 bit platforms
+	nodes   int64
+	aliases int64
+	// failed is set once err is
+	failed  int32
+	options Options
+	// ctx includes the time limit, which parent doesn't
+	ctx    context.Context
+	parent context.Context
+	done   <-chan struct{}
+	mu     sync.Mutex
+	err    error
+}
+
+func newGuard(ctx, parent context.Context, options Options) *guard {
+	return &guard{options: options, ctx: ctx, parent: parent, done: ctx.Done()}
+}
+
+// withTimeLimit bounds the context by the time limit, if there is one
+func withTimeLimit(ctx context.Context, options Options) (context.Context, context.CancelFunc) {
+	if options.Timeout > 0 {
+		return context.WithTimeout(ctx, options.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// enterNode counts a node at the given depth, reporting whether hashing may
+// carry on. The path is only built when a limit trips.
+func (g *guard) enterNode(depth int, path func() string) bool {
+	if g == nil {
+		return true
+	}
+	if atomic.LoadInt32(&g.failed) != 0 {
+		return false
+	}
+	nodes := atomic.AddInt64(&g.nodes, 1)
+	switch {
+	case g.options.MaxNodes > 0 && nodes > int64(g.options.MaxNodes):
+		return g.fail(&LimitError{Limit: NodeLimit, Path: path()})
+	case g.options.MaxDepth > 0 && depth > g.options.MaxDepth:
+		return g.fail(&LimitError{Limit: DepthLimit, Path: path()})
+	}
+	return g.checkContext(path)
+}
+
+// expandAlias counts an alias or merge key expansion, reporting whether
+// hashing may carry on
+func (g *guard) expandAlias(path func() string) bool {
+	if g == nil {
+		return true
+	}
+	if atomic.LoadInt32(&g.failed) != 0 {
+		return false
+	}
+	aliases := atomic.AddInt64(&g.aliases, 1)
+	if g.options.MaxAliasExpansions > 0 && aliases > int64(g.options.MaxAliasExpansions) {
+		return g.fail(&LimitError{Limit: AliasLimit, Path: path()})
+	}
+	return true
+}
+
+// checkContext stops the diff when the time limit passes or the caller
+// cancels it, reporting whether it may carry on
+func (g *guard) checkContext(path func() string) bool {
+	if g == nil {
+		return true
+	}
+	if atomic.LoadInt32(&g.failed) != 0 {
+		return false
+	}
+	select {
+	case <-g.done:
+	default:
+		return true
+	}
+	if g.parent.Err() == nil {
+		// only the time limit has passed
+		return g.fail(&LimitError{Limit: TimeLimit, Path: path()})
+	}
+	return g.fail(g.ctx.Err())
+}
+
+// fail stops the diff with the first error any goroutine runs into, and
+// reports that it may not carry on
+func (g *guard) fail(err error) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err == nil {
+		g.err = err
+		atomic.StoreInt32(&g.failed, 1)
+	}
+	return false
+}
+
+// keyPath renders the segments below a document the same way as HashedNodes
+func keyPath(docKey string, segments []PathSegment) string {
+	keys := make([]string, len(segments))
+	for index, segment := range segments {
+		keys[index] = segmentName(segment)
+	}
+	return "doc" + docKey + "." + strings.Join(keys, ".")
+}
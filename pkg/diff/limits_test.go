@@ -0,0 +1,123 @@
+package diff_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/corbym/gocrest/is"
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+const laughs = `
+a: &a [lol, lol, lol, lol]
+b: &b [*a, *a, *a, *a]
+c: &c [*b, *b, *b, *b]
+d: &d [*c, *c, *c, *c]
+e: [*d, *d, *d, *d]
+`
+
+// billionLaughs expands to nine to the power of seven scalars
+const billionLaughs = `
+a: &a [lol, lol, lol, lol, lol, lol, lol, lol, lol]
+b: &b [*a, *a, *a, *a, *a, *a, *a, *a, *a]
+c: &c [*b, *b, *b, *b, *b, *b, *b, *b, *b]
+d: &d [*c, *c, *c, *c, *c, *c, *c, *c, *c]
+e: &e [*d, *d, *d, *d, *d, *d, *d, *d, *d]
+f: &f [*e, *e, *e, *e, *e, *e, *e, *e, *e]
+g: &g [*f, *f, *f, *f, *f, *f, *f, *f, *f]
+`
+
+func TestLimits(t *testing.T) {
+	parse := func(src string) *yaml.Node {
+		var doc yaml.Node
+		err := yaml.Unmarshal([]byte(src), &doc)
+		assertThat(t, err, is.Nil())
+		return &doc
+	}
+	limitOf := func(err error) *diff.LimitError {
+		var limitErr *diff.LimitError
+		assertThat(t, errors.As(err, &limitErr), is.True())
+		return limitErr
+	}
+
+	doc := parse(laughs)
+	changes, err := diff.GetYamlNodeChanges(doc, doc)
+	assertThat(t, err, is.Nil())
+	assertThat(t, len(changes), is.EqualTo(0))
+
+	_, err = diff.GetYamlNodeChanges(doc, doc, diff.WithMaxAliasExpansions(20))
+	assertThat(t, limitOf(err).Limit, is.EqualTo(diff.AliasLimit))
+	assertThat(t, limitOf(err).Path, is.EqualTo("doc.c.[3].[0]"))
+
+	_, err = diff.GetYamlNodeChanges(doc, doc, diff.WithMaxNodes(100))
+	assertThat(t, limitOf(err).Limit, is.EqualTo(diff.NodeLimit))
+
+	_, err = diff.GetYamlNodeChanges(doc, doc, diff.WithMaxDepth(3))
+	assertThat(t, limitOf(err).Limit, is.EqualTo(diff.DepthLimit))
+	assertThat(t, limitOf(err).Path, is.EqualTo("doc.c.[0].[0].[0]"))
+
+	_, err = diff.GetYamlNodeChanges(doc, doc, diff.WithTimeout(time.Nanosecond))
+	assertThat(t, limitOf(err).Limit, is.EqualTo(diff.TimeLimit))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = diff.GetYamlNodeChangesContext(ctx, doc, doc, diff.WithTimeout(time.Minute))
+	assertThat(t, err, is.EqualTo(context.Canceled))
+}
+
+func TestTimeLimitStopsSequenceDiff(t *testing.T) {
+	items1 := make([]*yaml.Node, 6000)
+	items2 := make([]*yaml.Node, len(items1))
+	for index := range items1 {
+		item := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprint(index)}
+		items1[index] = item
+		items2[len(items2)-1-index] = item
+	}
+	doc := func(items []*yaml.Node) *yaml.Node {
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: items}
+		return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{seq}}
+	}
+
+	start := time.Now()
+	_, err := diff.GetYamlNodeChanges(doc(items1), doc(items2), diff.WithTimeout(100*time.Millisecond))
+	var limitErr *diff.LimitError
+	assertThat(t, errors.As(err, &limitErr), is.True())
+	assertThat(t, limitErr.Limit, is.EqualTo(diff.TimeLimit))
+	assertThat(t, limitErr.Path, is.EqualTo("doc."))
+	assertThat(t, time.Since(start) < time.Second, is.True())
+}
+
+func TestDefaultAliasLimitStopsBillionLaughs(t *testing.T) {
+	var doc yaml.Node
+	assertThat(t, yaml.Unmarshal([]byte(billionLaughs), &doc), is.Nil())
+
+	start := time.Now()
+	_, err := diff.GetYamlNodeChanges(&doc, &doc)
+	var limitErr *diff.LimitError
+	assertThat(t, errors.As(err, &limitErr), is.True())
+	assertThat(t, limitErr.Limit, is.EqualTo(diff.AliasLimit))
+	assertThat(t, time.Since(start) < 5*time.Second, is.True())
+
+	_, err = diff.GetYamlStreamChanges([]*yaml.Node{&doc}, []*yaml.Node{&doc})
+	assertThat(t, errors.As(err, &limitErr), is.True())
+}
+
+func TestHashNodeContextLimitsAliases(t *testing.T) {
+	var doc yaml.Node
+	assertThat(t, yaml.Unmarshal([]byte(billionLaughs), &doc), is.Nil())
+
+	_, err := diff.HashNodeContext(context.Background(), &doc)
+	var limitErr *diff.LimitError
+	assertThat(t, errors.As(err, &limitErr), is.True())
+	assertThat(t, limitErr.Limit, is.EqualTo(diff.AliasLimit))
+
+	var small yaml.Node
+	assertThat(t, yaml.Unmarshal([]byte(laughs), &small), is.Nil())
+	hashed, err := diff.HashNodeContext(context.Background(), &small)
+	assertThat(t, err, is.Nil())
+	assertThat(t, hashed.Hash, is.EqualTo(diff.HashNode(&small).Hash))
+}
@@ -0,0 +1,45 @@
+package diff
+
+import (
+	"strings"
+
+	"github.com/wjase/diffyaml/pkg/array"
+)
+
+// LineChange is a line added to or removed from a multi-line scalar
+type LineChange struct {
+	ChangeType ChangeType `yaml:"type"`
+	// Offset is the line's index in the old value for removed lines and in
+	// the new value for added ones
+	Offset int    `yaml:"offset"`
+	Text   string `yaml:"text"`
+}
+
+// withLineDiff attaches the changed lines to a change between two
+// multi-line scalars
+func (d *differ) withLineDiff(change ChangeLogEntry) ChangeLogEntry {
+	if !d.options.LineDiffs || change.ChangeType != Changed || change.From == nil || change.To == nil {
+		return change
+	}
+	from, to := change.From.Value, change.To.Value
+	if !strings.Contains(from, "\n") && !strings.Contains(to, "\n") {
+		return change
+	}
+	change.LineDiff = d.lineDiff(from, to, change.Path.String)
+	return change
+}
+
+// lineDiff lists the lines removed from and added to a value
+func (d *differ) lineDiff(from, to string, path func() string) []LineChange {
+	diffs := d.diffStrings(strings.Split(from, "\n"), strings.Split(to, "\n"), path)
+	lines := make([]LineChange, 0, len(diffs))
+	for _, each := range diffs {
+		switch each.Code {
+		case array.DeleteItem:
+			lines = append(lines, LineChange{ChangeType: Deleted, Offset: each.FromIndex, Text: each.FromValue})
+		case array.AddItem:
+			lines = append(lines, LineChange{ChangeType: Added, Offset: each.ToIndex, Text: each.ToValue})
+		}
+	}
+	return lines
+}
@@ -0,0 +1,228 @@
+package diff
+
+import "time"
+
+// Options control how two yaml documents are compared
+type Options struct {
+	// MatchResources pairs the documents in multi-document streams by their
+	// kubernetes resource identity instead of by their position
+	MatchResources bool
+	// SemanticScalars compares scalars by their resolved value rather than
+	// how they were written, so 1.0 matches 1 and ~ matches null
+	SemanticScalars bool
+	// identityKeys match the items of sequences of mappings by the values of
+	// some of their fields rather than by position. They're set by
+	// WithIdentityKeys.
+	identityKeys []identityRule
+	// SimilarityThreshold pairs deleted and added sequence items at least
+	// this similar as modified, and moved, items. Zero turns it off.
+	SimilarityThreshold float64
+	// RenameThreshold also reports a deleted and added key as renamed when
+	// their values are at least this similar. Keys with an equal mapping or
+	// sequence value, which no other key shares, are always reported as
+	// renamed. Zero turns it off.
+	RenameThreshold float64
+	// Comments reports changes to the comments on nodes
+	Comments bool
+	// Styles reports changes to how nodes are written, such as quoting or
+	// flow style, separately from changes to their values
+	Styles bool
+	// ignorePaths leaves the subtrees matching any of these patterns out of
+	// the comparison. They're set by WithIgnoredPaths.
+	ignorePaths []pathPattern
+	// unorderedPaths compares the sequences whose items match any of these
+	// patterns as multisets, so reordering their items isn't a change.
+	// They're set by WithUnorderedSequences.
+	unorderedPaths []pathPattern
+	// LineDiffs attaches the changed lines to changes of multi-line scalars
+	LineDiffs bool
+	// MaxNodes caps the number of nodes hashed, including those expanded
+	// from aliases. Zero means no limit.
+	MaxNodes int
+	// MaxDepth caps how deeply nodes may be nested. Zero means no limit.
+	MaxDepth int
+	// MaxAliasExpansions caps the number of aliases and merge keys expanded.
+	// It defaults to DefaultMaxAliasExpansions. Zero means no limit.
+	MaxAliasExpansions int
+	// Timeout caps how long a diff may run. Zero means no limit.
+	Timeout time.Duration
+	// Workers caps the number of goroutines hashing large documents. Zero
+	// means one per CPU.
+	Workers int
+	// SequenceCostLimit bounds the work spent finding a minimal diff of a
+	// sequence, after which a near minimal one is used. Zero means no limit.
+	SequenceCostLimit int
+}
+
+// Option sets one of the diff Options
+type Option func(*Options)
+
+// WithResourceMatching pairs the documents in multi-document streams by
+// apiVersion, kind, metadata.namespace and metadata.name
+func WithResourceMatching() Option {
+	return func(o *Options) {
+		o.MatchResources = true
+	}
+}
+
+// WithSemanticScalars canonicalizes scalars by their resolved yaml type
+// before comparing them, so formatting only edits such as 0x10 to 16, 1.0 to
+// 1, yes to true or ~ to null aren't reported as changes
+func WithSemanticScalars() Option {
+	return func(o *Options) {
+		o.SemanticScalars = true
+	}
+}
+
+// WithIdentityKeys matches the sequence items matching a path pattern by the
+// values of the given fields, eg
+//
+//	WithIdentityKeys("spec.template.spec.containers[*]", "name")
+//	WithIdentityKeys("**.parameters[*]", "name", "in")
+//
+// A * segment matches any key, [*] any index and ** any number of segments.
+// The pattern names the items, so it ends with an index.
+func WithIdentityKeys(pattern string, fields ...string) Option {
+	return func(o *Options) {
+		o.identityKeys = append(o.identityKeys, identityRule{compilePathPattern(pattern), fields})
+	}
+}
+
+// WithIgnoredPaths skips the subtrees matching any of the path patterns, eg
+//
+//	WithIgnoredPaths("metadata.annotations.*", "status", "info.version")
+//
+// Ignored subtrees aren't hashed or compared, so large ones cost nothing.
+// Patterns use the same wildcards as WithIdentityKeys.
+func WithIgnoredPaths(patterns ...string) Option {
+	return func(o *Options) {
+		for _, pattern := range patterns {
+			o.ignorePaths = append(o.ignorePaths, compilePathPattern(pattern))
+		}
+	}
+}
+
+// WithUnorderedSequences treats the sequences whose items match any of the
+// path patterns as multisets, eg
+//
+//	WithUnorderedSequences("**.required[*]", "**.enum[*]", "rules[*].verbs[*]")
+//
+// Only items whose number of copies changed are reported, as added or deleted.
+func WithUnorderedSequences(patterns ...string) Option {
+	return func(o *Options) {
+		for _, pattern := range patterns {
+			o.unorderedPaths = append(o.unorderedPaths, compilePathPattern(pattern))
+		}
+	}
+}
+
+// WithSimilarityThreshold pairs up sequence items which were both moved and
+// edited when at least the given fraction of their descendants are unchanged.
+// Nested changes are reported under the item's new position.
+func WithSimilarityThreshold(threshold float64) Option {
+	return func(o *Options) {
+		o.SimilarityThreshold = threshold
+	}
+}
+
+// WithRenameSimilarity reports a deleted and an added key under the same
+// mapping as renamed when their values are at least the given fraction
+// similar, followed by the changes within the value under the new key. Keys
+// whose values are the same mapping or sequence, which no other key shares,
+// are reported as renamed without this option. Scalar values are never
+// taken as renames.
+func WithRenameSimilarity(threshold float64) Option {
+	return func(o *Options) {
+		o.RenameThreshold = threshold
+	}
+}
+
+// WithComments compares the head, line and foot comments of each node and
+// reports any difference as a comment change on the node's path
+func WithComments() Option {
+	return func(o *Options) {
+		o.Comments = true
+	}
+}
+
+// WithStyles compares the style of each node, such as quoted, literal, folded
+// or flow, and reports any difference as a style change on the node's path
+func WithStyles() Option {
+	return func(o *Options) {
+		o.Styles = true
+	}
+}
+
+// WithLineDiffs diffs the lines of multi-line scalars which changed and
+// attaches the added and removed lines to the change
+func WithLineDiffs() Option {
+	return func(o *Options) {
+		o.LineDiffs = true
+	}
+}
+
+// WithMaxNodes stops a diff with a LimitError once more than the given
+// number of nodes have been hashed, counting nodes expanded from aliases
+func WithMaxNodes(max int) Option {
+	return func(o *Options) {
+		o.MaxNodes = max
+	}
+}
+
+// WithMaxDepth stops a diff with a LimitError at any node nested more
+// deeply than the given depth
+func WithMaxDepth(max int) Option {
+	return func(o *Options) {
+		o.MaxDepth = max
+	}
+}
+
+// DefaultMaxAliasExpansions is the number of aliases and merge keys a diff
+// expands before giving up, unless WithMaxAliasExpansions says otherwise. It's
+// plenty for hand written documents, but stops a few lines of exponentially
+// nested aliases, a "billion laughs" document, expanding without end.
+const DefaultMaxAliasExpansions = 10000
+
+// WithMaxAliasExpansions stops a diff with a LimitError once more than the
+// given number of aliases and merge keys have been expanded, which guards
+// against documents built from exponentially nested aliases. It replaces
+// DefaultMaxAliasExpansions, and zero removes the limit.
+func WithMaxAliasExpansions(max int) Option {
+	return func(o *Options) {
+		o.MaxAliasExpansions = max
+	}
+}
+
+// WithTimeout stops a diff with a LimitError once it has run for longer than
+// the given duration
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.Timeout = timeout
+	}
+}
+
+// WithWorkers caps the number of goroutines used to hash the subtrees of
+// large mappings and sequences. One hashes everything serially.
+func WithWorkers(workers int) Option {
+	return func(o *Options) {
+		o.Workers = workers
+	}
+}
+
+// WithSequenceCostLimit bounds the work spent diffing very different
+// sequences. Once finding the middle of an edit script costs more than the
+// limit, the sequence is split where the search got furthest instead, so the
+// reported adds and deletes may not be the fewest possible.
+func WithSequenceCostLimit(costLimit int) Option {
+	return func(o *Options) {
+		o.SequenceCostLimit = costLimit
+	}
+}
+
+func newOptions(opts []Option) Options {
+	options := Options{MaxAliasExpansions: DefaultMaxAliasExpansions}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
@@ -0,0 +1,339 @@
+package diff
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PathSegment is one step of a Path, either a mapping key or a sequence index
+type PathSegment struct {
+	Key   string
+	Index int
+	// IsIndex is true for sequence indexes and false for mapping keys
+	IsIndex bool
+}
+
+// KeySegment is a step into a mapping
+func KeySegment(key string) PathSegment {
+	return PathSegment{Key: key}
+}
+
+// IndexSegment is a step into a sequence
+func IndexSegment(index int) PathSegment {
+	return PathSegment{Index: index, IsIndex: true}
+}
+
+// Path locates a node within a yaml document, or a stream of them. Unlike
+// the dotted form, it isn't ambiguous when keys contain dots or look like
+// indexes.
+type Path struct {
+	// Document is the index of the document in a multi-document stream. It's
+	// nil for single documents.
+	Document *int
+	Segments []PathSegment
+}
+
+// DocumentPath is the path of a whole document in a multi-document stream
+func DocumentPath(index int) Path {
+	return Path{Document: &index}
+}
+
+// Child returns the path extended by a segment
+func (p Path) Child(segment PathSegment) Path {
+	segments := make([]PathSegment, len(p.Segments), len(p.Segments)+1)
+	copy(segments, p.Segments)
+	return Path{Document: p.Document, Segments: append(segments, segment)}
+}
+
+// Parent returns the path without its last segment
+func (p Path) Parent() Path {
+	if len(p.Segments) == 0 {
+		return p
+	}
+	return Path{Document: p.Document, Segments: p.Segments[:len(p.Segments)-1]}
+}
+
+// Last returns the final segment, if there is one
+func (p Path) Last() (PathSegment, bool) {
+	if len(p.Segments) == 0 {
+		return PathSegment{}, false
+	}
+	return p.Segments[len(p.Segments)-1], true
+}
+
+// Equal reports whether the paths locate the same node
+func (p Path) Equal(other Path) bool {
+	if (p.Document == nil) != (other.Document == nil) ||
+		(p.Document != nil && *p.Document != *other.Document) ||
+		len(p.Segments) != len(other.Segments) {
+		return false
+	}
+	for ind, segment := range p.Segments {
+		if segment != other.Segments[ind] {
+			return false
+		}
+	}
+	return true
+}
+
+// Less reports whether the path sorts before another. Paths sort by
+// document, then segment by segment with indexes in numeric order, and a
+// path sorts before the paths below it.
+func (p Path) Less(other Path) bool {
+	if (p.Document == nil) != (other.Document == nil) {
+		return p.Document == nil
+	}
+	if p.Document != nil && *p.Document != *other.Document {
+		return *p.Document < *other.Document
+	}
+	for ind, segment := range p.Segments {
+		if ind >= len(other.Segments) {
+			return false
+		}
+		otherSegment := other.Segments[ind]
+		switch {
+		case segment == otherSegment:
+			continue
+		case segment.IsIndex && otherSegment.IsIndex:
+			return segment.Index < otherSegment.Index
+		case !segment.IsIndex && !otherSegment.IsIndex:
+			return segment.Key < otherSegment.Key
+		}
+		// an index and a key sort as they're written
+		return segmentName(segment) < segmentName(otherSegment)
+	}
+	return len(p.Segments) < len(other.Segments)
+}
+
+// segmentName renders a segment as it's written in the dotted form
+func segmentName(segment PathSegment) string {
+	if segment.IsIndex {
+		return "[" + strconv.Itoa(segment.Index) + "]"
+	}
+	return segment.Key
+}
+
+// String renders the legacy dotted form, eg doc.a.b.[0] or doc[1].a. It's
+// ambiguous when keys contain dots or look like indexes.
+func (p Path) String() string {
+	bld := strings.Builder{}
+	bld.WriteString(p.documentPrefix())
+	if p.Document != nil && len(p.Segments) == 0 {
+		// a whole document
+		return bld.String()
+	}
+	bld.WriteString(".")
+	for ind, segment := range p.Segments {
+		if ind > 0 {
+			bld.WriteString(".")
+		}
+		if segment.IsIndex {
+			fmt.Fprintf(&bld, "[%d]", segment.Index)
+		} else {
+			bld.WriteString(segment.Key)
+		}
+	}
+	return bld.String()
+}
+
+// Quoted renders the bracket-quoted form, eg doc.["a.b"][0] or doc[1].["a"],
+// which always parses back to the same path
+func (p Path) Quoted() string {
+	bld := strings.Builder{}
+	bld.WriteString(p.documentPrefix())
+	bld.WriteString(".")
+	for _, segment := range p.Segments {
+		if segment.IsIndex {
+			fmt.Fprintf(&bld, "[%d]", segment.Index)
+		} else {
+			fmt.Fprintf(&bld, "[%s]", strconv.Quote(segment.Key))
+		}
+	}
+	return bld.String()
+}
+
+// JSONPointer renders the RFC 6901 JSON Pointer to the node within its
+// document, eg /paths/~1estimates~1price/get. The document index isn't part of
+// a pointer.
+func (p Path) JSONPointer() string {
+	bld := strings.Builder{}
+	for _, segment := range p.Segments {
+		bld.WriteString("/")
+		if segment.IsIndex {
+			bld.WriteString(strconv.Itoa(segment.Index))
+		} else {
+			bld.WriteString(pointerEscaper.Replace(segment.Key))
+		}
+	}
+	return bld.String()
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+func (p Path) documentPrefix() string {
+	if p.Document == nil {
+		return "doc"
+	}
+	return fmt.Sprintf("doc[%d]", *p.Document)
+}
+
+// MarshalYAML writes paths in the dotted form when it reads back as the same
+// path, and otherwise in the bracket-quoted form, eg when a key contains a
+// dot or looks like an index
+func (p Path) MarshalYAML() (interface{}, error) {
+	dotted := p.String()
+	if parsed, err := parsePath(dotted); err == nil && parsed.Equal(p) {
+		return dotted, nil
+	}
+	return p.Quoted(), nil
+}
+
+// UnmarshalYAML reads paths in the bracket-quoted form, falling back to the
+// dotted form
+func (p *Path) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := parsePath(value.Value)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// parsePath parses the bracket-quoted form, falling back to the dotted form
+func parsePath(value string) (Path, error) {
+	if parsed, err := ParseQuotedPath(value); err == nil {
+		return parsed, nil
+	}
+	return ParseDottedPath(value)
+}
+
+// ParseDottedPath parses the legacy dotted form. Keys containing dots, or
+// which look like indexes, can't be told apart from nesting and indexes.
+func ParseDottedPath(dotted string) (Path, error) {
+	path, rest, err := parseDocumentPrefix(dotted)
+	if err != nil || rest == "" {
+		return path, err
+	}
+	for _, part := range strings.Split(rest, ".") {
+		if index, ok := parseIndexKey(part); ok {
+			path.Segments = append(path.Segments, IndexSegment(index))
+		} else {
+			path.Segments = append(path.Segments, KeySegment(part))
+		}
+	}
+	return path, nil
+}
+
+// ParseQuotedPath parses the bracket-quoted form
+func ParseQuotedPath(quoted string) (Path, error) {
+	path, rest, err := parseDocumentPrefix(quoted)
+	if err != nil {
+		return path, err
+	}
+	for rest != "" {
+		if !strings.HasPrefix(rest, "[") {
+			return Path{}, fmt.Errorf("expected [ at %q in path %q", rest, quoted)
+		}
+		if strings.HasPrefix(rest, `["`) {
+			key, err := strconv.QuotedPrefix(rest[1:])
+			if err != nil || !strings.HasPrefix(rest[1+len(key):], "]") {
+				return Path{}, fmt.Errorf("bad quoted key at %q in path %q", rest, quoted)
+			}
+			unquoted, _ := strconv.Unquote(key)
+			path.Segments = append(path.Segments, KeySegment(unquoted))
+			rest = rest[len(key)+2:]
+			continue
+		}
+		close := strings.Index(rest, "]")
+		index, ok := parseIndexKey(rest[:close+1])
+		if close < 0 || !ok {
+			return Path{}, fmt.Errorf("bad index at %q in path %q", rest, quoted)
+		}
+		path.Segments = append(path.Segments, IndexSegment(index))
+		rest = rest[close+1:]
+	}
+	return path, nil
+}
+
+// ParseJSONPointer parses an RFC 6901 JSON Pointer. Whether a token like 0
+// is a key or an index depends on the document, so numeric tokens are read
+// as indexes.
+func ParseJSONPointer(pointer string) (Path, error) {
+	path := Path{}
+	if pointer == "" {
+		return path, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return path, fmt.Errorf("json pointer %q should start with /", pointer)
+	}
+	for _, token := range strings.Split(pointer[1:], "/") {
+		if index, err := strconv.Atoi(token); err == nil && index >= 0 && strconv.Itoa(index) == token {
+			path.Segments = append(path.Segments, IndexSegment(index))
+		} else {
+			path.Segments = append(path.Segments, KeySegment(pointerUnescaper.Replace(token)))
+		}
+	}
+	return path, nil
+}
+
+// parseDocumentPrefix reads the doc or doc[n] a path starts with, returning
+// what follows the dot after it
+func parseDocumentPrefix(value string) (Path, string, error) {
+	path := Path{}
+	if !strings.HasPrefix(value, "doc") {
+		return path, "", fmt.Errorf("path %q should start with doc", value)
+	}
+	rest := value[len("doc"):]
+	if strings.HasPrefix(rest, "[") {
+		close := strings.Index(rest, "]")
+		if close < 0 {
+			return path, "", fmt.Errorf("bad document index in path %q", value)
+		}
+		index, ok := parseIndexKey(rest[:close+1])
+		if !ok {
+			return path, "", fmt.Errorf("bad document index in path %q", value)
+		}
+		path.Document = &index
+		rest = rest[close+1:]
+	}
+	if rest == "" && path.Document != nil {
+		return path, "", nil
+	}
+	if !strings.HasPrefix(rest, ".") {
+		return path, "", fmt.Errorf("expected . after the document in path %q", value)
+	}
+	return path, rest[1:], nil
+}
+
+// parseIndexKey reads a sequence index key, eg [3]
+func parseIndexKey(key string) (int, bool) {
+	if !isIndexKey(key) {
+		return 0, false
+	}
+	index, err := strconv.Atoi(key[1 : len(key)-1])
+	return index, err == nil && index >= 0
+}
+
+// Path returns the structured path of the node
+func (h *HashedNode) Path() Path {
+	path := Path{}
+	top := h
+	for top.Parent != nil {
+		top = top.Parent
+	}
+	if index, ok := parseIndexKey(top.Key); ok {
+		path.Document = &index
+	}
+	for _, node := range h.GetPath() {
+		if index, ok := parseIndexKey(node.Key); ok && node.Parent.Node.Kind == yaml.SequenceNode {
+			path.Segments = append(path.Segments, IndexSegment(index))
+		} else {
+			path.Segments = append(path.Segments, KeySegment(node.Key))
+		}
+	}
+	return path
+}
@@ -0,0 +1,93 @@
+package diff
+
+import (
+	"strconv"
+	"strings"
+)
+
+// pathPattern matches node paths segment by segment. A * segment matches
+// any key, [*] matches any sequence index and ** matches any number of
+// segments, eg spec.template.spec.containers[*] or **.parameters[*]. The
+// patterns of sequence rules name the items, so they end with an index.
+type pathPattern []string
+
+// compilePathPattern splits a pattern into segments. Patterns are relative to
+// the document root and may start with doc.
+func compilePathPattern(pattern string) pathPattern {
+	pattern = strings.TrimPrefix(pattern, "doc.")
+	segments := pathPattern{}
+	for _, part := range strings.Split(pattern, ".") {
+		// split trailing indexes off their keys, eg containers[*]
+		for {
+			open := strings.Index(part, "[")
+			if open < 0 || !strings.HasSuffix(part, "]") {
+				break
+			}
+			if open > 0 {
+				segments = append(segments, part[:open])
+			}
+			close := strings.Index(part[open:], "]") + open
+			segments = append(segments, part[open:close+1])
+			part = part[close+1:]
+		}
+		if part != "" {
+			segments = append(segments, part)
+		}
+	}
+	return segments
+}
+
+// matchesSequence reports whether the pattern matches the items of a
+// sequence, such as containers[*], given the items on either side
+func (p pathPattern) matchesSequence(seq1, seq2 HashedNodes) bool {
+	var item *HashedNode
+	if len(seq2) > 0 {
+		item = seq2[0]
+	} else if len(seq1) > 0 {
+		item = seq1[0]
+	} else {
+		return false
+	}
+	segments := item.Path().Segments
+	if len(segments) == 0 || !segments[len(segments)-1].IsIndex {
+		// the content of a document, rather than a sequence item
+		return false
+	}
+	return matchSegments(p, segments)
+}
+
+// matchSegments matches a pattern against the segments of a path. Whether a
+// segment is an index comes from the kind of its parent node, so a mapping
+// key which looks like [0] is still a key.
+func matchSegments(pattern pathPattern, segments []PathSegment) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	switch part := pattern[0]; {
+	case part == "**":
+		for skip := 0; skip <= len(segments); skip++ {
+			if matchSegments(pattern[1:], segments[skip:]) {
+				return true
+			}
+		}
+		return false
+	case len(segments) == 0:
+		return false
+	case matchSegment(part, segments[0]):
+		return matchSegments(pattern[1:], segments[1:])
+	}
+	return false
+}
+
+// matchSegment matches one part of a pattern against a segment. Parts in
+// brackets only match indexes, and the rest only match keys.
+func matchSegment(part string, segment PathSegment) bool {
+	if segment.IsIndex {
+		return part == "[*]" || part == "["+strconv.Itoa(segment.Index)+"]"
+	}
+	return part == "*" || part == segment.Key && !isIndexKey(part)
+}
+
+func isIndexKey(key string) bool {
+	return strings.HasPrefix(key, "[") && strings.HasSuffix(key, "]")
+}
@@ -0,0 +1,49 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// segments builds a path from keys and indexes
+func segments(steps ...interface{}) []PathSegment {
+	path := []PathSegment{}
+	for _, step := range steps {
+		if index, ok := step.(int); ok {
+			path = append(path, IndexSegment(index))
+		} else {
+			path = append(path, KeySegment(step.(string)))
+		}
+	}
+	return path
+}
+
+func TestPathPatternMatches(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		segments []PathSegment
+		matches  bool
+	}{
+		{"spec.template.spec.containers[*]", segments("spec", "template", "spec", "containers", 2), true},
+		{"doc.spec.template.spec.containers[*]", segments("spec", "template", "spec", "containers", 0), true},
+		{"spec.template.spec.containers[*]", segments("spec", "template", "spec", "containers"), false},
+		{"spec.*.spec", segments("spec", "template", "spec"), true},
+		{"spec.*.spec", segments("spec", 0, "spec"), false},
+		{"**.parameters[*]", segments("paths", "/pets", "get", "parameters", 1), true},
+		{"**.parameters[*]", segments("parameters", 1), true},
+		{"**.parameters[*]", segments("parameters", 1, "name"), false},
+		{"metadata.annotations.*", segments("metadata", "annotations", "owner"), true},
+		{"matrix[*][1]", segments("matrix", 0, 1), true},
+		{"matrix[*][1]", segments("matrix", 0, 2), false},
+		// keys which look like indexes are still keys
+		{"a[*]", segments("a", "[0]"), false},
+		{"a.*", segments("a", "[0]"), true},
+		{"a[0]", segments("a", "[0]"), false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.pattern, func(t *testing.T) {
+			require.Equal(t, tC.matches, matchSegments(compilePathPattern(tC.pattern), tC.segments))
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/corbym/gocrest/is"
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPathForms(t *testing.T) {
+	one := 1
+	path := diff.Path{Document: &one, Segments: []diff.PathSegment{
+		diff.KeySegment("paths"),
+		diff.KeySegment("/estimates/price"),
+		diff.KeySegment("a.b"),
+		diff.IndexSegment(2),
+		diff.KeySegment("~tilde"),
+	}}
+	assertThat(t, path.String(), is.EqualTo("doc[1].paths./estimates/price.a.b.[2].~tilde"))
+	assertThat(t, path.Quoted(), is.EqualTo(`doc[1].["paths"]["/estimates/price"]["a.b"][2]["~tilde"]`))
+	assertThat(t, path.JSONPointer(), is.EqualTo("/paths/~1estimates~1price/a.b/2/~0tilde"))
+
+	quoted, err := diff.ParseQuotedPath(path.Quoted())
+	assertThat(t, err, is.Nil())
+	assertThat(t, quoted.Equal(path), is.True())
+
+	pointer, err := diff.ParseJSONPointer(path.JSONPointer())
+	assertThat(t, err, is.Nil())
+	assertThat(t, pointer.Equal(diff.Path{Segments: path.Segments}), is.True())
+
+	dotted, err := diff.ParseDottedPath("doc[1].paths./estimates/price.[2]")
+	assertThat(t, err, is.Nil())
+	assertThat(t, dotted.Quoted(), is.EqualTo(`doc[1].["paths"]["/estimates/price"][2]`))
+}
+
+func TestPathRoundTrips(t *testing.T) {
+	for _, quoted := range []string{
+		"doc.",
+		"doc[0].",
+		`doc.[0]["[1]"]`,
+		`doc.["quote\"d"]["new\nline"][10]`,
+		`doc[3].[""]["ünïcode"]`,
+	} {
+		path, err := diff.ParseQuotedPath(quoted)
+		assertThat(t, err, is.Nil())
+		assertThat(t, path.Quoted(), is.EqualTo(quoted))
+		pointer, err := diff.ParseJSONPointer(path.JSONPointer())
+		assertThat(t, err, is.Nil())
+		assertThat(t, pointer.JSONPointer(), is.EqualTo(path.JSONPointer()))
+	}
+	for _, bad := range []string{"", "root.a", `doc.["a"`, "doc.[x]", "doc[1"} {
+		_, err := diff.ParseQuotedPath(bad)
+		assertThat(t, err, not(is.Nil()))
+	}
+}
+
+func TestNodePathsTellKeysFromIndexes(t *testing.T) {
+	var doc yaml.Node
+	err := yaml.Unmarshal([]byte(`{"[0]": [a, {"a.b": c}]}`), &doc)
+	assertThat(t, err, is.Nil())
+	hashed := diff.HashNode(&doc)
+	item := hashed.Children[0].Children[0].Children[1].Children[0]
+	assertThat(t, item.Path().Quoted(), is.EqualTo(`doc.["[0]"][1]["a.b"]`))
+	assertThat(t, item.Path().String(), is.EqualTo("doc.[0].[1].a.b"))
+}
+
+func TestPathsMarshalSoTheyReadBack(t *testing.T) {
+	for _, test := range []struct {
+		path diff.Path
+		want string
+	}{
+		{
+			path: diff.Path{Segments: []diff.PathSegment{diff.KeySegment("paths"), diff.KeySegment("/a/"), diff.IndexSegment(0)}},
+			want: "doc.paths./a/.[0]\n",
+		},
+		{
+			path: diff.Path{Segments: []diff.PathSegment{diff.KeySegment("[0]"), diff.IndexSegment(1)}},
+			want: "doc.[\"[0]\"][1]\n",
+		},
+		{
+			path: diff.Path{Segments: []diff.PathSegment{diff.KeySegment("a.b")}},
+			want: "doc.[\"a.b\"]\n",
+		},
+		{
+			path: diff.Path{Segments: []diff.PathSegment{diff.KeySegment(`["k"]`)}},
+			want: "doc.[\"[\\\"k\\\"]\"]\n",
+		},
+	} {
+		out, err := yaml.Marshal(test.path)
+		assertThat(t, err, is.Nil())
+		assertThat(t, string(out), is.EqualTo(test.want))
+
+		var read diff.Path
+		assertThat(t, yaml.Unmarshal(out, &read), is.Nil())
+		assertThat(t, read.Equal(test.path), is.True())
+	}
+}
+
+func TestPathsSortBySegment(t *testing.T) {
+	paths := []string{"doc.", "doc.a", "doc.a.[2]", "doc.a.[10]", "doc.a.b", "doc.b", "doc[0].a", "doc[1]."}
+	for index := 1; index < len(paths); index++ {
+		before, err := diff.ParseDottedPath(paths[index-1])
+		assertThat(t, err, is.Nil())
+		after, err := diff.ParseDottedPath(paths[index])
+		assertThat(t, err, is.Nil())
+		assertThat(t, before.Less(after), is.True())
+		assertThat(t, after.Less(before), is.False())
+	}
+}
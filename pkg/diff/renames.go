@@ -0,0 +1,103 @@
+package diff
+
+import "sort"
+
+// mergeRenamedKeys pairs the deleted and added keys of a mapping whose values
+// are equal, or at least as similar as the rename threshold when one is set,
+// and reports them as renamed. The unpaired keys are returned.
+//
+// Equal values are only paired when no other deleted or added key has the
+// same value, so old: 8080 becoming new: 8080 is a rename but swapping keys
+// with common values such as true or 1 isn't guessed at. Scalars are never
+// paired by similarity, as few scalars are partly the same.
+func (d *differ) mergeRenamedKeys(deleted, added HashedNodes) (ChangeLogEntries, HashedNodes, HashedNodes) {
+	changes := ChangeLogEntries{}
+	if len(deleted) == 0 || len(added) == 0 {
+		return changes, deleted, added
+	}
+
+	deletedCounts := contentHashCounts(deleted)
+	addedCounts := contentHashCounts(added)
+	paired := map[*HashedNode]bool{}
+	for _, item1 := range deleted {
+		if deletedCounts[item1.contentHash] != 1 || addedCounts[item1.contentHash] != 1 {
+			continue
+		}
+		for _, item2 := range added {
+			if item1.contentHash == item2.contentHash {
+				paired[item1] = true
+				paired[item2] = true
+				changes = append(changes, renamedKey(item1, item2))
+				if item1.Hash != item2.Hash {
+					changes = append(changes, d.diffNode(item1, item2)...)
+				}
+				break
+			}
+		}
+	}
+
+	if threshold := d.options.RenameThreshold; threshold > 0 {
+		type candidate struct {
+			item1, item2 *HashedNode
+			score        float64
+		}
+		candidates := []candidate{}
+		for _, item1 := range deleted {
+			for _, item2 := range added {
+				if paired[item1] || paired[item2] || item1.Node.Kind != item2.Node.Kind || item1.IsScalar() {
+					continue
+				}
+				if score := Similarity(item1, item2); score >= threshold {
+					candidates = append(candidates, candidate{item1, item2, score})
+				}
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].score > candidates[j].score
+		})
+		for _, each := range candidates {
+			if paired[each.item1] || paired[each.item2] {
+				continue
+			}
+			paired[each.item1] = true
+			paired[each.item2] = true
+			changes = append(changes, renamedKey(each.item1, each.item2))
+			changes = append(changes, d.diffNode(each.item1, each.item2)...)
+		}
+	}
+
+	return changes, unpaired(deleted, paired), unpaired(added, paired)
+}
+
+func renamedKey(item1, item2 *HashedNode) ChangeLogEntry {
+	return ChangeLogEntry{
+		Path:       item2.Path(),
+		ChangeType: Renamed,
+		From:       item1.Node,
+		To:         item2.Node,
+		FromKey:    item1.Key,
+		ToKey:      item2.Key,
+		Line:       &item2.Node.Line,
+		Column:     &item2.Node.Column,
+		Anchor:     anchorOf(item2, item1),
+	}
+}
+
+// contentHashCounts counts the items with each content hash
+func contentHashCounts(items HashedNodes) map[string]int {
+	counts := map[string]int{}
+	for _, item := range items {
+		counts[item.contentHash]++
+	}
+	return counts
+}
+
+func unpaired(items HashedNodes, paired map[*HashedNode]bool) HashedNodes {
+	remaining := HashedNodes{}
+	for _, item := range items {
+		if !paired[item] {
+			remaining = remaining.Add(item)
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,59 @@
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/corbym/gocrest/is"
+	"github.com/stretchr/testify/require"
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenamesNeedAUniqueEqualValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     []string
+	}{
+		{
+			name: "scalars",
+			from: "a: 1\nb: 2\n",
+			to:   "c: 1\nd: 2\n",
+			want: []string{"renamed doc.c", "renamed doc.d"},
+		},
+		{
+			name: "scalars with the same value",
+			from: "a: 1\nb: 1\n",
+			to:   "c: 1\nd: 1\n",
+			want: []string{"deleted doc.a", "deleted doc.b", "added doc.c", "added doc.d"},
+		},
+		{
+			name: "mapping",
+			from: "a: {x: 1}\n",
+			to:   "b: {x: 1}\n",
+			want: []string{"renamed doc.b"},
+		},
+		{
+			name: "mappings with the same value",
+			from: "a: {x: 1}\nb: {x: 1}\n",
+			to:   "c: {x: 1}\nd: {x: 1}\n",
+			want: []string{"deleted doc.a", "deleted doc.b", "added doc.c", "added doc.d"},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			var from, to yaml.Node
+			require.NoError(t, yaml.Unmarshal([]byte(test.from), &from))
+			require.NoError(t, yaml.Unmarshal([]byte(test.to), &to))
+
+			changes, err := diff.GetYamlNodeChanges(&from, &to)
+			require.NoError(t, err)
+			summary := make([]string, len(changes))
+			for ind, change := range changes {
+				summary[ind] = change.ChangeType.String() + " " + change.Path.String()
+			}
+			assertThat(t, summary, is.EqualTo(test.want))
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// diffDocumentsByResource pairs documents which describe the same kubernetes
+// resource, wherever they are in the stream. Unmatched documents are reported
+// as whole document adds and deletes, and matched documents which changed
+// position are reported as moved.
+func (d *differ) diffDocumentsByResource(docs1, docs2 HashedNodes) ChangeLogEntries {
+	pairs := d.pairByIdentity(resourceIdentities(docs1), resourceIdentities(docs2), func() string { return "doc." })
+	changes := ChangeLogEntries{}
+
+	for _, pair := range pairs.aligned {
+		changes = append(changes, d.diffNode(docs1[pair[0]], docs2[pair[1]])...)
+	}
+	for _, pair := range pairs.moved {
+		changes = append(changes, documentMove(pair[0], pair[1], docs2[pair[1]]))
+		changes = append(changes, d.diffNode(docs1[pair[0]], docs2[pair[1]])...)
+	}
+	for _, index := range pairs.deleted {
+		changes = append(changes, documentChange(Deleted, index, docs1[index]))
+	}
+	for _, index := range pairs.added {
+		changes = append(changes, documentChange(Added, index, docs2[index]))
+	}
+	return changes
+}
+
+// documentMove reports a document which moved within the stream
+func documentMove(fromIndex, toIndex int, doc *HashedNode) ChangeLogEntry {
+	root := documentRoot(doc)
+	return ChangeLogEntry{
+		Path:       DocumentPath(fromIndex),
+		ChangeType: Moved,
+		FromIndex:  &fromIndex,
+		ToIndex:    &toIndex,
+		Line:       &root.Line,
+		Column:     &root.Column,
+	}
+}
+
+// resourceIdentities returns an identity for each document. Documents which
+// aren't kubernetes resources are identified by their position amongst the
+// other unidentified documents.
+func resourceIdentities(docs HashedNodes) []string {
+	ids := make([]string, len(docs))
+	unidentified := 0
+	for index, doc := range docs {
+		if id, ok := resourceIdentity(doc); ok {
+			ids[index] = id
+			continue
+		}
+		ids[index] = fmt.Sprintf("[%d]", unidentified)
+		unidentified++
+	}
+	return ids
+}
+
+// resourceIdentity returns apiVersion/kind/namespace/name for a document
+// holding a kubernetes resource
+func resourceIdentity(doc *HashedNode) (string, bool) {
+	if len(doc.Children) == 0 || doc.Children[0].Node.Kind != yaml.MappingNode {
+		return "", false
+	}
+	root := doc.Children[0]
+	kind := scalarChild(root, "kind")
+	metadata := findChild(root, "metadata")
+	if kind == "" || metadata == nil {
+		return "", false
+	}
+	name := scalarChild(metadata, "name")
+	if name == "" {
+		return "", false
+	}
+	return strings.Join([]string{
+		scalarChild(root, "apiVersion"),
+		kind,
+		scalarChild(metadata, "namespace"),
+		name,
+	}, "/"), true
+}
+
+func findChild(node *HashedNode, key string) *HashedNode {
+	_, child := node.Children.Find(key)
+	return child
+}
+
+func scalarChild(node *HashedNode, key string) string {
+	child := findChild(node, key)
+	if child == nil || !child.IsScalar() {
+		return ""
+	}
+	return child.Node.Value
+}
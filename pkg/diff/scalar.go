@@ -0,0 +1,62 @@
+package diff
+
+import (
+	"math"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// legacyBools are the yaml 1.1 spellings of booleans which yaml 1.2 reads as strings
+var legacyBools = map[string]bool{
+	"y": true, "Y": true, "yes": true, "Yes": true, "YES": true,
+	"on": true, "On": true, "ON": true,
+	"n": false, "N": false, "no": false, "No": false, "NO": false,
+	"off": false, "Off": false, "OFF": false,
+}
+
+// canonicalScalar returns the resolved tag and a canonical spelling of the
+// value of a scalar. Integers and floats share a numeric form so 1.0 and 1
+// match, and plain yaml 1.1 booleans such as yes and off are read as booleans.
+func canonicalScalar(node *yaml.Node) (tag, value string) {
+	tag = node.ShortTag()
+	switch tag {
+	case "!!null":
+		return tag, "null"
+	case "!!bool":
+		var b bool
+		if err := node.Decode(&b); err == nil {
+			return tag, strconv.FormatBool(b)
+		}
+	case "!!int", "!!float":
+		var f float64
+		if err := node.Decode(&f); err == nil {
+			return canonicalNumber(node, f)
+		}
+	case "!!str":
+		if b, ok := legacyBools[node.Value]; ok && node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) == 0 {
+			return "!!bool", strconv.FormatBool(b)
+		}
+	}
+	return tag, node.Value
+}
+
+// canonicalNumber formats whole numbers as integers and everything else in
+// the shortest float form
+func canonicalNumber(node *yaml.Node, f float64) (string, string) {
+	if node.ShortTag() == "!!int" {
+		// decode integers exactly as float64 loses precision past 2^53
+		var i int64
+		if err := node.Decode(&i); err == nil {
+			return "!!int", strconv.FormatInt(i, 10)
+		}
+		var u uint64
+		if err := node.Decode(&u); err == nil {
+			return "!!int", strconv.FormatUint(u, 10)
+		}
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return "!!int", strconv.FormatInt(int64(f), 10)
+	}
+	return "!!float", strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,73 @@
+package diff_test
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+// editedSequences builds a sequence of items and a copy of it with some of
+// the items deleted, some added and some moved elsewhere
+func editedSequences(size, edits int, item func(int) string) (string, string) {
+	random := rand.New(rand.NewSource(42))
+	from := make([]string, size)
+	for ind := range from {
+		from[ind] = item(ind)
+	}
+	to := append([]string{}, from...)
+	for ind := 0; ind < edits; ind++ {
+		// delete one, add one and move one
+		at := random.Intn(len(to))
+		to = append(to[:at], to[at+1:]...)
+		at = random.Intn(len(to))
+		to = append(to[:at], append([]string{item(size + ind)}, to[at:]...)...)
+		from, to2 := random.Intn(len(to)), random.Intn(len(to))
+		moved := to[from]
+		to = append(to[:from], to[from+1:]...)
+		to = append(to[:to2], append([]string{moved}, to[to2:]...)...)
+	}
+	return "items:\n" + strings.Join(from, ""), "items:\n" + strings.Join(to, "")
+}
+
+func benchmarkSequenceDiff(b *testing.B, item func(int) string, opts ...diff.Option) {
+	for _, size := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("items=%d", size), func(b *testing.B) {
+			src1, src2 := editedSequences(size, size/20, item)
+			var doc1, doc2 yaml.Node
+			if err := yaml.Unmarshal([]byte(src1), &doc1); err != nil {
+				b.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(src2), &doc2); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for ind := 0; ind < b.N; ind++ {
+				if _, err := diff.GetYamlNodeChanges(&doc1, &doc2, opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDiffScalarSequence(b *testing.B) {
+	benchmarkSequenceDiff(b, func(ind int) string {
+		return fmt.Sprintf("  - item%d\n", ind)
+	})
+}
+
+func BenchmarkDiffSequenceOfSequences(b *testing.B) {
+	benchmarkSequenceDiff(b, func(ind int) string {
+		return fmt.Sprintf("  - [item%d, %d]\n", ind, ind*7)
+	})
+}
+
+func BenchmarkDiffScalarSequenceCostLimited(b *testing.B) {
+	benchmarkSequenceDiff(b, func(ind int) string {
+		return fmt.Sprintf("  - item%d\n", ind)
+	}, diff.WithSequenceCostLimit(64))
+}
@@ -0,0 +1,86 @@
+package diff
+
+import "sort"
+
+// Similarity scores how alike two subtrees are by the fraction of their
+// descendants, identified by key and hash, which they share. Equal subtrees
+// score 1 and subtrees with nothing in common score 0.
+func Similarity(node1, node2 *HashedNode) float64 {
+	if node1.contentHash == node2.contentHash {
+		return 1
+	}
+	descendants1 := descendantHashes(node1, map[string]int{})
+	descendants2 := descendantHashes(node2, map[string]int{})
+	total1, total2, shared := 0, 0, 0
+	for key, count1 := range descendants1 {
+		total1 += count1
+		if count2, ok := descendants2[key]; ok {
+			if count2 < count1 {
+				shared += count2
+			} else {
+				shared += count1
+			}
+		}
+	}
+	for _, count2 := range descendants2 {
+		total2 += count2
+	}
+	if total1+total2 == 0 {
+		return 0
+	}
+	return float64(2*shared) / float64(total1+total2)
+}
+
+// descendantHashes counts the key and hash of each descendant of a node
+func descendantHashes(node *HashedNode, counts map[string]int) map[string]int {
+	for _, child := range node.Children {
+		counts[child.Key+":"+child.contentHash]++
+		descendantHashes(child, counts)
+	}
+	return counts
+}
+
+// mergeSimilarChanges pairs the remaining deletes and adds in a sequence
+// whose items are at least threshold similar, most similar first.
+func mergeSimilarChanges(changes []SequenceChangeLogEntry, threshold float64) []SequenceChangeLogEntry {
+	type candidate struct {
+		delIndex, addIndex int
+		score              float64
+	}
+	candidates := []candidate{}
+	for delIndex, eachDelete := range changes {
+		if eachDelete.ChangeType != Deleted {
+			continue
+		}
+		for addIndex, eachAdd := range changes {
+			if eachAdd.ChangeType != Added || eachAdd.To.Node.Kind != eachDelete.From.Node.Kind {
+				continue
+			}
+			if score := Similarity(eachDelete.From, eachAdd.To); score >= threshold {
+				candidates = append(candidates, candidate{delIndex, addIndex, score})
+			}
+		}
+	}
+	// stable so equally similar pairs are taken in sequence order
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	paired := map[int]bool{}
+	mergedChanges := []SequenceChangeLogEntry{}
+	for _, each := range candidates {
+		if paired[each.delIndex] || paired[each.addIndex] {
+			continue
+		}
+		paired[each.delIndex] = true
+		paired[each.addIndex] = true
+		entry := pairedChange(changes[each.delIndex], changes[each.addIndex])
+		mergedChanges = append(mergedChanges, entry)
+	}
+	for ind, eachChange := range changes {
+		if !paired[ind] {
+			mergedChanges = append(mergedChanges, eachChange)
+		}
+	}
+	return mergedChanges
+}
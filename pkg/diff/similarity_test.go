@@ -0,0 +1,24 @@
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/corbym/gocrest/is"
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSimilarity(t *testing.T) {
+	hashed := func(src string) *diff.HashedNode {
+		var doc yaml.Node
+		err := yaml.Unmarshal([]byte(src), &doc)
+		assertThat(t, err, is.Nil())
+		return diff.HashNode(&doc).Children[0]
+	}
+
+	route := hashed("{path: /api, service: api, port: 8080, timeout: 30}")
+	assertThat(t, diff.Similarity(route, route), is.EqualTo(1.0))
+	assertThat(t, diff.Similarity(route, hashed("{path: /api, service: api, port: 8443, timeout: 30}")), is.EqualTo(0.75))
+	assertThat(t, diff.Similarity(route, hashed("{url: /api, name: api, port: 80}")), is.EqualTo(0.0))
+	assertThat(t, diff.Similarity(hashed("a"), hashed("b")), is.EqualTo(0.0))
+}
@@ -0,0 +1,58 @@
+package diff
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// styleLabels name each of the yaml node style flags
+var styleLabels = []struct {
+	style yaml.Style
+	label string
+}{
+	{yaml.TaggedStyle, "tagged"},
+	{yaml.DoubleQuotedStyle, "double-quoted"},
+	{yaml.SingleQuotedStyle, "single-quoted"},
+	{yaml.LiteralStyle, "literal"},
+	{yaml.FoldedStyle, "folded"},
+	{yaml.FlowStyle, "flow"},
+}
+
+// styleLabel describes how a node is written, eg plain, double-quoted or flow
+func styleLabel(node *yaml.Node) string {
+	labels := []string{}
+	for _, each := range styleLabels {
+		if node.Style&each.style != 0 {
+			labels = append(labels, each.label)
+		}
+	}
+	if len(labels) > 0 {
+		return strings.Join(labels, ",")
+	}
+	switch node.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		return "block"
+	case yaml.ScalarNode:
+		return "plain"
+	}
+	return ""
+}
+
+// diffStyles reports a change to the style a node is written in
+func diffStyles(node1, node2 *HashedNode) ChangeLogEntries {
+	fromStyle, toStyle := styleLabel(node1.Node), styleLabel(node2.Node)
+	if fromStyle == toStyle {
+		return nil
+	}
+	line, column := node2.Node.Line, node2.Node.Column
+	return ChangeLogEntries{{
+		Path:       node2.Path(),
+		ChangeType: StyleChanged,
+		FromStyle:  fromStyle,
+		ToStyle:    toStyle,
+		Line:       &line,
+		Column:     &column,
+		Anchor:     anchorOf(node2, node1),
+	}}
+}
@@ -0,0 +1,85 @@
+package diff
+
+// isUnordered reports whether the items of the sequences match any of the
+// unordered patterns, so they're compared as multisets
+func (d *differ) isUnordered(seq1, seq2 HashedNodes) bool {
+	for _, pattern := range d.options.unorderedPaths {
+		if pattern.matchesSequence(seq1, seq2) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffSequenceAsSet compares two sequences as multisets. The nth copy of an
+// item on one side is paired with the nth copy on the other, and any copies
+// left over were added or deleted. When the number of copies of a duplicated
+// item changed, the entries carry both counts.
+func (d *differ) diffSequenceAsSet(seq1, seq2 HashedNodes) ChangeLogEntries {
+	copies1, order1 := groupByContent(seq1)
+	copies2, order2 := groupByContent(seq2)
+	changes := ChangeLogEntries{}
+
+	for _, hash := range order1 {
+		items1, items2 := copies1[hash], copies2[hash]
+		for index := range items1 {
+			if index < len(items2) {
+				if item1, item2 := seq1[items1[index]], seq2[items2[index]]; item1.Hash != item2.Hash {
+					changes = append(changes, d.diffNode(item1, item2)...)
+				}
+				continue
+			}
+			fromIndex, item := items1[index], seq1[items1[index]]
+			change := ChangeLogEntry{
+				Path:       item.Path(),
+				ChangeType: Deleted,
+				FromIndex:  &fromIndex,
+				From:       item.Node,
+				Line:       &item.Node.Line,
+				Column:     &item.Node.Column,
+				Anchor:     item.Anchor,
+			}
+			changes = append(changes, withCounts(change, len(items1), len(items2)))
+		}
+	}
+	for _, hash := range order2 {
+		items1, items2 := copies1[hash], copies2[hash]
+		for index := len(items1); index < len(items2); index++ {
+			toIndex, item := items2[index], seq2[items2[index]]
+			change := ChangeLogEntry{
+				Path:       item.Path(),
+				ChangeType: Added,
+				ToIndex:    &toIndex,
+				To:         item.Node,
+				Line:       &item.Node.Line,
+				Column:     &item.Node.Column,
+				Anchor:     item.Anchor,
+			}
+			changes = append(changes, withCounts(change, len(items1), len(items2)))
+		}
+	}
+	return changes
+}
+
+// groupByContent returns the indexes of the items with each content hash,
+// along with the hashes in the order they first appear
+func groupByContent(items HashedNodes) (map[string][]int, []string) {
+	copies := map[string][]int{}
+	order := []string{}
+	for index, item := range items {
+		if _, ok := copies[item.contentHash]; !ok {
+			order = append(order, item.contentHash)
+		}
+		copies[item.contentHash] = append(copies[item.contentHash], index)
+	}
+	return copies, order
+}
+
+// withCounts records how many copies of a duplicated item there were
+func withCounts(change ChangeLogEntry, fromCount, toCount int) ChangeLogEntry {
+	if fromCount > 1 || toCount > 1 {
+		change.FromCount = &fromCount
+		change.ToCount = &toCount
+	}
+	return change
+}
@@ -0,0 +1,94 @@
+// Package fixtures lists the suites of yaml fixtures which the tests diff,
+// each with the options it's diffed with, so the diff, patch and report
+// tests all run the same cases.
+package fixtures
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+// Suite is a directory of fixtures. Each name.from.yaml is diffed with the
+// name.to.yaml next to it, and name.diffs.yaml holds the expected changes.
+type Suite struct {
+	// Name is the directory under fixtures
+	Name    string
+	Options []diff.Option
+	// Decorations is set when the options only add comment or style
+	// changes, which a patch doesn't apply and json doesn't hold
+	Decorations bool
+	// Semantic is set when the options leave out changes, such as yes
+	// becoming true, which json tells apart
+	Semantic bool
+}
+
+// Suites are all the fixtures with their options
+var Suites = []Suite{
+	{Name: "simple"},
+	{Name: "swagger"},
+	{Name: "multidoc"},
+	{Name: "anchors"},
+	{Name: "resources", Options: []diff.Option{diff.WithResourceMatching()}},
+	{Name: "semantic", Options: []diff.Option{diff.WithSemanticScalars()}, Semantic: true},
+	{Name: "identity", Options: []diff.Option{
+		diff.WithIdentityKeys("spec.template.spec.containers[*]", "name"),
+		diff.WithIdentityKeys("**.parameters[*]", "name", "in"),
+	}},
+	{Name: "similarity", Options: []diff.Option{diff.WithSimilarityThreshold(0.5)}},
+	{Name: "renames", Options: []diff.Option{diff.WithRenameSimilarity(0.6)}},
+	{Name: "comments", Options: []diff.Option{diff.WithComments()}, Decorations: true},
+	{Name: "styles", Options: []diff.Option{diff.WithStyles()}, Decorations: true},
+	{Name: "ignore", Options: []diff.Option{
+		diff.WithIgnoredPaths("metadata.annotations.*", "status", "**.generated")}},
+	{Name: "unordered", Options: []diff.Option{
+		diff.WithUnorderedSequences("**.required[*]", "**.enum[*]", "rules[*].verbs[*]", "tags[*]")}},
+	{Name: "lines", Options: []diff.Option{diff.WithLineDiffs()}},
+}
+
+// Dir is the fixtures directory, relative to the packages under pkg which
+// the tests run in
+var Dir = filepath.Join("..", "..", "fixtures")
+
+// ToFiles lists the new side of each pair of fixtures in the suite
+func (s Suite) ToFiles() ([]string, error) {
+	return filepath.Glob(filepath.Join(Dir, s.Name, "*.to.yaml"))
+}
+
+// FromFile returns the old side of a pair of fixtures
+func FromFile(toFile string) string {
+	return toFile[:len(toFile)-len("to.yaml")] + "from.yaml"
+}
+
+// ForEach runs a test for each pair of fixtures in the suites, with the
+// changes between them and the options they were diffed with. Comment and
+// style changes are left out, as patches don't apply them and json doesn't
+// hold them.
+func ForEach(t *testing.T, suites []Suite, test func(t *testing.T, docs1, docs2 []*yaml.Node, changes diff.ChangeLogEntries, options []diff.Option)) {
+	for _, suite := range suites {
+		options := suite.Options
+		if suite.Decorations {
+			options = nil
+		}
+		toFiles, err := suite.ToFiles()
+		require.NoError(t, err)
+		require.NotEmpty(t, toFiles, "no fixtures in %s", suite.Name)
+
+		for _, toFile := range toFiles {
+			fromFile := FromFile(toFile)
+			t.Run(suite.Name+"/"+filepath.Base(toFile), func(t *testing.T) {
+				docs1, err := diff.ReadYAMLStream(fromFile)
+				require.NoError(t, err)
+				docs2, err := diff.ReadYAMLStream(toFile)
+				require.NoError(t, err)
+
+				changes, err := diff.GetYamlStreamChanges(docs1, docs2, options...)
+				require.NoError(t, err)
+				test(t, docs1, docs2, changes, options)
+			})
+		}
+	}
+}
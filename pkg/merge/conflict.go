@@ -0,0 +1,71 @@
+package merge
+
+import (
+	"io"
+
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+// ConflictType describes how the two sides' edits of a node conflict
+type ConflictType int
+
+const (
+	// BothChanged both sides changed the node, or added it, differently
+	BothChanged ConflictType = iota
+	// DeletedAndChanged one side deleted the node and the other changed it
+	DeletedAndChanged
+	// BothRenamed both sides renamed the key, to different names
+	BothRenamed
+)
+
+// ConflictTypeLabels used for printing conflicts
+var ConflictTypeLabels = []string{"both-changed", "deleted-and-changed", "both-renamed"}
+
+// String implement the Stringer interface
+func (c ConflictType) String() string {
+	return ConflictTypeLabels[c]
+}
+
+// MarshalYAML custom marshal function
+func (c ConflictType) MarshalYAML() (interface{}, error) {
+	return ConflictTypeLabels[c], nil
+}
+
+// Conflict is a node both sides edited in ways which can't both be kept.
+// The merged document has ours' version of it.
+type Conflict struct {
+	// Path is where the node is, or would be, in the base document
+	Path         diff.Path    `yaml:"path"`
+	ConflictType ConflictType `yaml:"conflict"`
+	// Base, Ours and Theirs are the node in each document, or nil where it's
+	// missing. For BothRenamed they're the keys.
+	Base   *yaml.Node `yaml:"base,omitempty"`
+	Ours   *yaml.Node `yaml:"ours,omitempty"`
+	Theirs *yaml.Node `yaml:"theirs,omitempty"`
+}
+
+// WriteConflicts reports the conflicts to the specified Writer, with the
+// aliases in their values expanded
+func WriteConflicts(conflicts []Conflict, w io.Writer) error {
+	expanded := make([]Conflict, len(conflicts))
+	for index, conflict := range conflicts {
+		expanded[index] = conflict
+		for _, value := range []**yaml.Node{&expanded[index].Base, &expanded[index].Ours, &expanded[index].Theirs} {
+			if *value == nil {
+				continue
+			}
+			copied, err := newRebuilder(nil).copy(*value)
+			if err != nil {
+				return err
+			}
+			*value = copied
+		}
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(expanded); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
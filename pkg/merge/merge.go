@@ -0,0 +1,470 @@
+package merge
+
+import (
+	"context"
+
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+// Merge merges the edits two sides, ours and theirs, made to a base
+// document. The changes of each side are found with diff.GetYamlNodeChanges
+// and the options. A node only one side changed takes that side's version,
+// and a node both sides changed is merged key by key or item by item. Where
+// the edits can't both be kept, the conflict is returned and the merged
+// document has ours' version. The resource limits in the options also bound
+// the hashing of the nodes the sides both changed.
+//
+// Sequences are merged when neither side moved their items, or only one
+// side edited them. Otherwise a sequence both sides changed differently is a
+// conflict as a whole, as are different items added at the same place.
+func Merge(base, ours, theirs *yaml.Node, opts ...diff.Option) (*yaml.Node, []Conflict, error) {
+	oursSide, err := newSide(base, ours, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	theirsSide, err := newSide(base, theirs, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := merger{ours: oursSide, theirs: theirsSide, replaced: map[*yaml.Node]*yaml.Node{}, options: opts}
+	merged := m.merge(diff.Path{}, root(base), root(ours), root(theirs))
+	if m.err != nil {
+		return nil, nil, m.err
+	}
+
+	content, err := newRebuilder(m.replaced).copy(merged)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc := yaml.Node{Kind: yaml.DocumentNode}
+	if ours.Kind == yaml.DocumentNode {
+		doc = *ours
+	}
+	doc.Content = []*yaml.Node{content}
+	return &doc, m.conflicts, nil
+}
+
+// root is the content of a document
+func root(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		return node.Content[0]
+	}
+	return node
+}
+
+// side is one of the edited documents, with its changes from the base
+type side struct {
+	paths *diff.PathMap
+	// changed holds the base paths of the nodes with changes at or under them
+	changed map[string]bool
+}
+
+func newSide(base, doc *yaml.Node, opts []diff.Option) (*side, error) {
+	changes, err := diff.GetYamlNodeChanges(base, doc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s := &side{paths: changes.PathMap(), changed: map[string]bool{}}
+	for _, change := range changes {
+		var path diff.Path
+		switch change.ChangeType {
+		case diff.NoChange:
+			continue
+		case diff.Deleted, diff.Moved:
+			// these are reported under the base path
+			path = change.Path
+		case diff.Renamed:
+			// renaming a key changes its mapping, not its value
+			path = s.basePath(change.Path).Parent()
+		default:
+			path = s.basePath(change.Path)
+		}
+		for length := 0; length <= len(path.Segments); length++ {
+			s.changed[diff.Path{Segments: path.Segments[:length]}.Quoted()] = true
+		}
+	}
+	return s, nil
+}
+
+// basePath is the path in the base document of the node at a path of the
+// side's document, or of its nearest parent which was in the base document
+func (s *side) basePath(path diff.Path) diff.Path {
+	for {
+		if basePath, ok := s.paths.ToOld(path); ok {
+			return basePath
+		}
+		path = path.Parent()
+	}
+}
+
+// baseIndexes returns the index in the base sequence of each item of the
+// side's sequence, or -1 for the items the side added
+func (s *side) baseIndexes(path diff.Path, items *yaml.Node, baseCount int) []int {
+	sidePath, _ := s.paths.ToNew(path)
+	indexes := make([]int, len(items.Content))
+	for index := range items.Content {
+		indexes[index] = -1
+		if basePath, ok := s.paths.ToOld(sidePath.Child(diff.IndexSegment(index))); ok {
+			if last, _ := basePath.Last(); last.IsIndex && last.Index < baseCount {
+				indexes[index] = last.Index
+			}
+		}
+	}
+	return indexes
+}
+
+// baseKey returns the key in the base mapping of a key of the side's
+// mapping, or false if the side added it
+func (s *side) baseKey(path diff.Path, key string, base []field) (string, bool) {
+	sidePath, _ := s.paths.ToNew(path)
+	basePath, ok := s.paths.ToOld(sidePath.Child(diff.KeySegment(key)))
+	if !ok {
+		return "", false
+	}
+	last, _ := basePath.Last()
+	_, inBase := lookupField(base, last.Key)
+	return last.Key, inBase
+}
+
+// sideKey returns the key in the side's mapping of a key of the base mapping,
+// or false if the side deleted it
+func (s *side) sideKey(path diff.Path, key string) (string, bool) {
+	sidePath, ok := s.paths.ToNew(path.Child(diff.KeySegment(key)))
+	if !ok {
+		return "", false
+	}
+	last, _ := sidePath.Last()
+	return last.Key, true
+}
+
+// merger merges the sides node by node, from the root down
+type merger struct {
+	ours, theirs *side
+	conflicts    []Conflict
+	// replaced maps the nodes of the sides to the merged nodes which replace
+	// them, so their aliases refer to the merged nodes
+	replaced map[*yaml.Node]*yaml.Node
+	// options bound the hashing of the nodes being compared
+	options []diff.Option
+	// err is the first error hashing the nodes
+	err error
+}
+
+func (m *merger) conflict(conflictType ConflictType, path diff.Path, base, ours, theirs *yaml.Node) {
+	m.conflicts = append(m.conflicts, Conflict{Path: path, ConflictType: conflictType, Base: base, Ours: ours, Theirs: theirs})
+}
+
+// merge merges the edits of a node of the base document which both sides
+// kept
+func (m *merger) merge(path diff.Path, base, ours, theirs *yaml.Node) *yaml.Node {
+	switch {
+	case !m.theirs.changed[path.Quoted()]:
+		return ours
+	case !m.ours.changed[path.Quoted()]:
+		return theirs
+	}
+	baseNode, oursNode, theirsNode := resolved(base), resolved(ours), resolved(theirs)
+	switch {
+	case m.same(oursNode, theirsNode):
+		return ours
+	case baseNode.Kind == yaml.MappingNode && oursNode.Kind == yaml.MappingNode && theirsNode.Kind == yaml.MappingNode:
+		return m.mergeMappings(path, baseNode, oursNode, theirsNode)
+	case baseNode.Kind == yaml.SequenceNode && oursNode.Kind == yaml.SequenceNode && theirsNode.Kind == yaml.SequenceNode:
+		return m.mergeSequences(path, baseNode, oursNode, theirsNode)
+	}
+	m.conflict(BothChanged, path, base, ours, theirs)
+	return ours
+}
+
+// container returns an empty copy of ours' node to hold the merged children,
+// which replaces the nodes of both sides
+func (m *merger) container(ours, theirs *yaml.Node) *yaml.Node {
+	merged := *ours
+	merged.Content = nil
+	m.replaced[ours] = &merged
+	m.replaced[theirs] = &merged
+	return &merged
+}
+
+// mappingBuilder adds the merged fields of a mapping
+type mappingBuilder struct {
+	node   *yaml.Node
+	values map[string]*yaml.Node
+}
+
+// add adds a field, unless the key has already been added
+func (b *mappingBuilder) add(key, value *yaml.Node) bool {
+	if _, ok := b.values[key.Value]; ok {
+		return false
+	}
+	b.values[key.Value] = value
+	b.node.Content = append(b.node.Content, key, value)
+	return true
+}
+
+// mergeMappings merges the keys of ours in their order, then adds the keys
+// only theirs added
+func (m *merger) mergeMappings(path diff.Path, base, ours, theirs *yaml.Node) *yaml.Node {
+	baseFields, theirsFields := fieldsOf(base), fieldsOf(theirs)
+	merged := mappingBuilder{node: m.container(ours, theirs), values: map[string]*yaml.Node{}}
+	add := func(key, value *yaml.Node, theirsValue *yaml.Node) {
+		if !merged.add(key, value) && !m.same(resolved(merged.values[key.Value]), resolved(theirsValue)) {
+			m.conflict(BothChanged, path.Child(diff.KeySegment(key.Value)), nil, merged.values[key.Value], theirsValue)
+		}
+	}
+	usedTheirs := map[string]bool{}
+
+	for _, oursField := range fieldsOf(ours) {
+		baseKey, inBase := m.ours.baseKey(path, oursField.key.Value, baseFields)
+		if !inBase {
+			// ours added it, and theirs may have too
+			theirsField, ok := lookupField(theirsFields, oursField.key.Value)
+			if _, theirsInBase := m.theirs.baseKey(path, oursField.key.Value, baseFields); ok && !theirsInBase {
+				usedTheirs[theirsField.key.Value] = true
+				if !m.same(resolved(oursField.value), resolved(theirsField.value)) {
+					m.conflict(BothChanged, path.Child(diff.KeySegment(oursField.key.Value)), nil, oursField.value, theirsField.value)
+				}
+			}
+			merged.add(oursField.key, oursField.value)
+			continue
+		}
+
+		baseField, _ := lookupField(baseFields, baseKey)
+		childPath := path.Child(diff.KeySegment(baseKey))
+		theirsKey, ok := m.theirs.sideKey(path, baseKey)
+		theirsField, inTheirs := lookupField(theirsFields, theirsKey)
+		if !ok || !inTheirs {
+			if m.ours.changed[childPath.Quoted()] {
+				m.conflict(DeletedAndChanged, childPath, baseField.value, oursField.value, nil)
+				merged.add(oursField.key, oursField.value)
+			}
+			continue
+		}
+		usedTheirs[theirsKey] = true
+
+		key := oursField.key
+		if key.Value != theirsKey {
+			switch {
+			case key.Value == baseKey:
+				// only theirs renamed it
+				key = theirsField.key
+			case theirsKey != baseKey:
+				m.conflict(BothRenamed, childPath, baseField.key, oursField.key, theirsField.key)
+			}
+		}
+		add(key, m.merge(childPath, baseField.value, oursField.value, theirsField.value), theirsField.value)
+	}
+
+	for _, theirsField := range theirsFields {
+		if usedTheirs[theirsField.key.Value] {
+			continue
+		}
+		baseKey, inBase := m.theirs.baseKey(path, theirsField.key.Value, baseFields)
+		if !inBase {
+			add(theirsField.key, theirsField.value, theirsField.value)
+			continue
+		}
+		// ours deleted it
+		childPath := path.Child(diff.KeySegment(baseKey))
+		if m.theirs.changed[childPath.Quoted()] {
+			baseField, _ := lookupField(baseFields, baseKey)
+			m.conflict(DeletedAndChanged, childPath, baseField.value, nil, theirsField.value)
+		}
+	}
+	return merged.node
+}
+
+// mergeSequences merges the items of sequences. When neither side moved
+// items, the items each side added go between the items of the base
+// sequence both kept. When one side moved items and the other didn't add,
+// delete or move any, the merged items follow the moving side.
+func (m *merger) mergeSequences(path diff.Path, base, ours, theirs *yaml.Node) *yaml.Node {
+	count := len(base.Content)
+	oursIndexes := m.ours.baseIndexes(path, ours, count)
+	theirsIndexes := m.theirs.baseIndexes(path, theirs, count)
+	switch {
+	case !moved(oursIndexes) && !moved(theirsIndexes):
+		merged := m.container(ours, theirs)
+		merged.Content = m.interleave(path, base, ours, theirs, oursIndexes, theirsIndexes)
+		return merged
+	case !edited(theirsIndexes, count):
+		merged := m.container(ours, theirs)
+		merged.Content = m.followMoves(path, base, ours, theirs, oursIndexes, true)
+		return merged
+	case !edited(oursIndexes, count) && !m.deletesChanged(path, base, theirsIndexes, m.ours):
+		merged := m.container(ours, theirs)
+		merged.Content = m.followMoves(path, base, ours, theirs, theirsIndexes, false)
+		return merged
+	}
+	m.conflict(BothChanged, path, base, ours, theirs)
+	return ours
+}
+
+// moved reports whether the items kept from the base sequence are out of
+// their order
+func moved(indexes []int) bool {
+	last := -1
+	for _, index := range indexes {
+		if index < 0 {
+			continue
+		}
+		if index <= last {
+			return true
+		}
+		last = index
+	}
+	return false
+}
+
+// edited reports whether items were added, deleted or moved
+func edited(indexes []int, baseCount int) bool {
+	if len(indexes) != baseCount {
+		return true
+	}
+	for index, baseIndex := range indexes {
+		if baseIndex != index {
+			return true
+		}
+	}
+	return false
+}
+
+// kept returns the index in the side's sequence of each item of the base
+// sequence, or -1 for the items the side deleted
+func kept(indexes []int, baseCount int) []int {
+	positions := make([]int, baseCount)
+	for index := range positions {
+		positions[index] = -1
+	}
+	for index, baseIndex := range indexes {
+		if baseIndex >= 0 {
+			positions[baseIndex] = index
+		}
+	}
+	return positions
+}
+
+// added returns the items the side added before each item of the base
+// sequence, and at the end
+func added(items *yaml.Node, indexes []int, baseCount int) [][]*yaml.Node {
+	gaps := make([][]*yaml.Node, baseCount+1)
+	gap := 0
+	for index, baseIndex := range indexes {
+		if baseIndex >= 0 {
+			gap = baseIndex + 1
+			continue
+		}
+		gaps[gap] = append(gaps[gap], items.Content[index])
+	}
+	return gaps
+}
+
+func (m *merger) interleave(path diff.Path, base, ours, theirs *yaml.Node, oursIndexes, theirsIndexes []int) []*yaml.Node {
+	count := len(base.Content)
+	oursKept, theirsKept := kept(oursIndexes, count), kept(theirsIndexes, count)
+	oursAdded, theirsAdded := added(ours, oursIndexes, count), added(theirs, theirsIndexes, count)
+	items := []*yaml.Node{}
+	for index := 0; index <= count; index++ {
+		items = append(items, oursAdded[index]...)
+		if len(theirsAdded[index]) > 0 {
+			if len(oursAdded[index]) == 0 {
+				items = append(items, theirsAdded[index]...)
+			} else if !m.sameItems(oursAdded[index], theirsAdded[index]) {
+				// both added different items at the same place
+				m.conflict(BothChanged, path, base, ours, theirs)
+			}
+		}
+		if index == count {
+			break
+		}
+
+		itemPath := path.Child(diff.IndexSegment(index))
+		oursIndex, theirsIndex := oursKept[index], theirsKept[index]
+		switch {
+		case oursIndex < 0 && theirsIndex < 0:
+		case oursIndex < 0:
+			if m.theirs.changed[itemPath.Quoted()] {
+				m.conflict(DeletedAndChanged, itemPath, base.Content[index], nil, theirs.Content[theirsIndex])
+			}
+		case theirsIndex < 0:
+			if m.ours.changed[itemPath.Quoted()] {
+				m.conflict(DeletedAndChanged, itemPath, base.Content[index], ours.Content[oursIndex], nil)
+				items = append(items, ours.Content[oursIndex])
+			}
+		default:
+			items = append(items, m.merge(itemPath, base.Content[index], ours.Content[oursIndex], theirs.Content[theirsIndex]))
+		}
+	}
+	return items
+}
+
+// deletesChanged reports whether one side deleted items the other changed
+func (m *merger) deletesChanged(path diff.Path, base *yaml.Node, indexes []int, other *side) bool {
+	for index, sideIndex := range kept(indexes, len(base.Content)) {
+		if sideIndex < 0 && other.changed[path.Child(diff.IndexSegment(index)).Quoted()] {
+			return true
+		}
+	}
+	return false
+}
+
+// followMoves merges the items in the order of the side which moved them.
+// The other side's items are where they were in the base sequence.
+func (m *merger) followMoves(path diff.Path, base, ours, theirs *yaml.Node, indexes []int, oursMoved bool) []*yaml.Node {
+	moving, other := theirs, ours
+	if oursMoved {
+		moving, other = ours, theirs
+		for index, oursIndex := range kept(indexes, len(base.Content)) {
+			itemPath := path.Child(diff.IndexSegment(index))
+			if oursIndex < 0 && m.theirs.changed[itemPath.Quoted()] {
+				m.conflict(DeletedAndChanged, itemPath, base.Content[index], nil, theirs.Content[index])
+			}
+		}
+	}
+	items := []*yaml.Node{}
+	for index, baseIndex := range indexes {
+		if baseIndex < 0 {
+			items = append(items, moving.Content[index])
+			continue
+		}
+		oursItem, theirsItem := moving.Content[index], other.Content[baseIndex]
+		if !oursMoved {
+			oursItem, theirsItem = theirsItem, oursItem
+		}
+		items = append(items, m.merge(path.Child(diff.IndexSegment(baseIndex)), base.Content[baseIndex], oursItem, theirsItem))
+	}
+	return items
+}
+
+// same reports whether nodes are equal, going by their hashes. Nodes which
+// can't be hashed within the limits of the options aren't the same, and the
+// error is kept for Merge to return.
+func (m *merger) same(node1, node2 *yaml.Node) bool {
+	if m.err != nil {
+		return false
+	}
+	hashed1, err := diff.HashNodeContext(context.Background(), node1, m.options...)
+	if err != nil {
+		m.err = err
+		return false
+	}
+	hashed2, err := diff.HashNodeContext(context.Background(), node2, m.options...)
+	if err != nil {
+		m.err = err
+		return false
+	}
+	return hashed1.Hash == hashed2.Hash
+}
+
+func (m *merger) sameItems(items1, items2 []*yaml.Node) bool {
+	if len(items1) != len(items2) {
+		return false
+	}
+	for index := range items1 {
+		if !m.same(resolved(items1[index]), resolved(items2[index])) {
+			return false
+		}
+	}
+	return true
+}
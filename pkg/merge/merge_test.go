@@ -0,0 +1,199 @@
+package merge_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/corbym/gocrest/has"
+	"github.com/corbym/gocrest/is"
+	"github.com/corbym/gocrest/then"
+	"github.com/stretchr/testify/require"
+	"github.com/wjase/diffyaml/pkg/diff"
+	"github.com/wjase/diffyaml/pkg/merge"
+	"gopkg.in/yaml.v3"
+)
+
+var assertThat = then.AssertThat
+
+func TestMergeKeepsBothSidesChanges(t *testing.T) {
+	tests := []struct {
+		name                     string
+		base, ours, theirs, want string
+		options                  []diff.Option
+	}{
+		{
+			name:   "keys",
+			base:   "a: 1\nb: 2\nc: 3\n",
+			ours:   "a: 10\nb: 2\nc: 3\nd: 4\n",
+			theirs: "a: 1\nb: 20\n",
+			want:   "a: 10\nb: 20\nd: 4\n",
+		},
+		{
+			name:   "nested keys",
+			base:   "spec:\n  replicas: 1\n  image: web:1\n",
+			ours:   "spec:\n  replicas: 3\n  image: web:1\n",
+			theirs: "spec:\n  replicas: 1\n  image: web:2\n  port: 80\n",
+			want:   "spec:\n  replicas: 3\n  image: web:2\n  port: 80\n",
+		},
+		{
+			name:   "sequence items added and deleted",
+			base:   "list: [a, b, c]\n",
+			ours:   "list: [a, b, c, d]\n",
+			theirs: "list: [b, x, c]\n",
+			want:   "list: [b, x, c, d]\n",
+		},
+		{
+			name:    "sequence items edited",
+			base:    "containers:\n- {name: web, image: web:1}\n- {name: db, image: db:1}\n",
+			ours:    "containers:\n- {name: web, image: web:2}\n- {name: db, image: db:1}\n",
+			theirs:  "containers:\n- {name: web, image: web:1}\n- {name: db, image: db:1, port: 5432}\n",
+			want:    "containers:\n- {name: web, image: web:2}\n- {name: db, image: db:1, port: 5432}\n",
+			options: []diff.Option{diff.WithIdentityKeys("containers[*]", "name")},
+		},
+		{
+			name:    "sequence items moved",
+			base:    "list:\n- {name: a, v: 1}\n- {name: b, v: 1}\n- {name: c, v: 1}\n",
+			ours:    "list:\n- {name: c, v: 1}\n- {name: a, v: 1}\n- {name: b, v: 1}\n",
+			theirs:  "list:\n- {name: a, v: 2}\n- {name: b, v: 1}\n- {name: c, v: 1}\n",
+			want:    "list:\n- {name: c, v: 1}\n- {name: a, v: 2}\n- {name: b, v: 1}\n",
+			options: []diff.Option{diff.WithIdentityKeys("list[*]", "name")},
+		},
+		{
+			name:    "renamed key",
+			base:    "servers:\n  web: {host: h, tls: true, port: 1}\n",
+			ours:    "servers:\n  api: {host: h, tls: true, port: 1}\n",
+			theirs:  "servers:\n  web: {host: h, tls: true, port: 2}\n",
+			want:    "servers:\n  api: {host: h, tls: true, port: 2}\n",
+			options: []diff.Option{diff.WithRenameSimilarity(0.5)},
+		},
+		{
+			name:   "same change",
+			base:   "a: 1\nb: 2\n",
+			ours:   "a: 3\nb: 2\n",
+			theirs: "a: 3\nb: 4\n",
+			want:   "a: 3\nb: 4\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			merged, conflicts, err := merge.Merge(parse(t, test.base), parse(t, test.ours), parse(t, test.theirs), test.options...)
+			require.NoError(t, err)
+			assertThat(t, conflicts, has.Length(0))
+
+			remaining, err := diff.GetYamlNodeChanges(merged, parse(t, test.want))
+			require.NoError(t, err)
+			assertThat(t, remaining, has.Length(0))
+		})
+	}
+}
+
+func TestMergeReportsConflicts(t *testing.T) {
+	tests := []struct {
+		name                     string
+		base, ours, theirs, want string
+		conflicts                []string
+	}{
+		{
+			name:      "both changed",
+			base:      "a: 1\nb: 2\n",
+			ours:      "a: 2\nb: 2\n",
+			theirs:    "a: 3\nb: 3\n",
+			want:      "a: 2\nb: 3\n",
+			conflicts: []string{"both-changed doc.a 1 2 3"},
+		},
+		{
+			name:      "deleted and changed",
+			base:      "a: {x: 1}\nb: {x: 1}\n",
+			ours:      "b: {x: 2}\n",
+			theirs:    "a: {x: 2}\n",
+			want:      "b: {x: 2}\n",
+			conflicts: []string{"deleted-and-changed doc.b {x: 1} {x: 2} -", "deleted-and-changed doc.a {x: 1} - {x: 2}"},
+		},
+		{
+			name:      "both added",
+			base:      "a: 1\n",
+			ours:      "a: 1\nb: 2\n",
+			theirs:    "a: 1\nb: 3\n",
+			want:      "a: 1\nb: 2\n",
+			conflicts: []string{"both-changed doc.b - 2 3"},
+		},
+		{
+			name:      "item deleted and changed",
+			base:      "list: [{a: 1}, {b: 1}]\n",
+			ours:      "list: [{a: 1}]\n",
+			theirs:    "list: [{a: 1}, {b: 2}]\n",
+			want:      "list: [{a: 1}]\n",
+			conflicts: []string{"deleted-and-changed doc.list.[1] {b: 1} - {b: 2}"},
+		},
+		{
+			name:      "items added at the same place",
+			base:      "list: [a]\n",
+			ours:      "list: [a, b]\n",
+			theirs:    "list: [a, c]\n",
+			want:      "list: [a, b]\n",
+			conflicts: []string{"both-changed doc.list [a] [a, b] [a, c]"},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			merged, conflicts, err := merge.Merge(parse(t, test.base), parse(t, test.ours), parse(t, test.theirs))
+			require.NoError(t, err)
+
+			summary := make([]string, len(conflicts))
+			for index, conflict := range conflicts {
+				summary[index] = conflict.ConflictType.String() + " " + conflict.Path.String() + " " +
+					flow(t, conflict.Base) + " " + flow(t, conflict.Ours) + " " + flow(t, conflict.Theirs)
+			}
+			assertThat(t, summary, is.EqualTo(test.conflicts))
+
+			remaining, err := diff.GetYamlNodeChanges(merged, parse(t, test.want))
+			require.NoError(t, err)
+			assertThat(t, remaining, has.Length(0))
+		})
+	}
+}
+
+func TestMergeKeepsAliases(t *testing.T) {
+	base := parse(t, "base: &b\n  port: 80\nsvc: *b\n")
+	ours := parse(t, "base: &b\n  port: 8080\nsvc: *b\n")
+	theirs := parse(t, "base: &b\n  port: 80\nsvc: *b\nname: x\n")
+
+	merged, conflicts, err := merge.Merge(base, ours, theirs)
+	require.NoError(t, err)
+	assertThat(t, conflicts, has.Length(0))
+	out, err := yaml.Marshal(merged)
+	require.NoError(t, err)
+	assertThat(t, string(out), is.EqualTo("base: &b\n    port: 8080\nsvc: *b\nname: x\n"))
+}
+
+func TestWriteConflicts(t *testing.T) {
+	base := parse(t, "a: 1\n")
+	ours := parse(t, "a: 2\n")
+	theirs := parse(t, "a: 3\n")
+
+	_, conflicts, err := merge.Merge(base, ours, theirs)
+	require.NoError(t, err)
+	buffer := bytes.Buffer{}
+	require.NoError(t, merge.WriteConflicts(conflicts, &buffer))
+	assertThat(t, buffer.String(), is.EqualTo("- path: doc.a\n  conflict: both-changed\n  base: 1\n  ours: 2\n  theirs: 3\n"))
+}
+
+func parse(t *testing.T, text string) *yaml.Node {
+	var doc yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte(text), &doc))
+	return &doc
+}
+
+// flow writes a node on one line, or - for none
+func flow(t *testing.T, node *yaml.Node) string {
+	if node == nil {
+		return "-"
+	}
+	copied := *node
+	copied.Style = yaml.FlowStyle
+	out, err := yaml.Marshal(&copied)
+	require.NoError(t, err)
+	return string(bytes.TrimSpace(out))
+}
@@ -0,0 +1,136 @@
+package merge
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// field is a key of a mapping and its value
+type field struct {
+	key, value *yaml.Node
+}
+
+// fieldsOf lists the keys and values of a mapping, followed by those of the
+// mappings merged into it which it doesn't override. Earlier merges override
+// later ones.
+func fieldsOf(mapping *yaml.Node) []field {
+	fields := []field{}
+	merges := []*yaml.Node{}
+	for index := 0; index+1 < len(mapping.Content); index += 2 {
+		key, value := mapping.Content[index], mapping.Content[index+1]
+		if key.Kind == yaml.ScalarNode && key.ShortTag() == "!!merge" {
+			merges = append(merges, resolved(value))
+			continue
+		}
+		fields = append(fields, field{key: key, value: value})
+	}
+	for _, merge := range merges {
+		mappings := []*yaml.Node{merge}
+		if merge.Kind == yaml.SequenceNode {
+			mappings = merge.Content
+		}
+		for _, merged := range mappings {
+			if merged = resolved(merged); merged.Kind != yaml.MappingNode {
+				continue
+			}
+			for _, mergedField := range fieldsOf(merged) {
+				if _, ok := lookupField(fields, mergedField.key.Value); !ok {
+					fields = append(fields, mergedField)
+				}
+			}
+		}
+	}
+	return fields
+}
+
+func lookupField(fields []field, key string) (field, bool) {
+	for _, field := range fields {
+		if field.key.Value == key {
+			return field, true
+		}
+	}
+	return field{}, false
+}
+
+// resolved follows documents to their content and aliases to their anchors
+func resolved(node *yaml.Node) *yaml.Node {
+	for {
+		switch {
+		case node.Kind == yaml.DocumentNode && len(node.Content) > 0:
+			node = node.Content[0]
+		case node.Kind == yaml.AliasNode:
+			node = node.Alias
+		default:
+			return node
+		}
+	}
+}
+
+// rebuilder copies the merged document, which is put together from nodes of
+// both sides. Aliases are kept when their anchor comes before them in the
+// merged document, and expanded otherwise.
+type rebuilder struct {
+	// replaced maps nodes of the sides to the merged nodes which replace them
+	replaced map[*yaml.Node]*yaml.Node
+	copies   map[*yaml.Node]*yaml.Node
+	// anchors holds the anchor names used so far, so the anchors of the two
+	// sides don't clash
+	anchors   map[string]bool
+	expanding map[*yaml.Node]bool
+}
+
+func newRebuilder(replaced map[*yaml.Node]*yaml.Node) *rebuilder {
+	return &rebuilder{
+		replaced:  replaced,
+		copies:    map[*yaml.Node]*yaml.Node{},
+		anchors:   map[string]bool{},
+		expanding: map[*yaml.Node]bool{},
+	}
+}
+
+func (r *rebuilder) copy(node *yaml.Node) (*yaml.Node, error) {
+	if node.Kind == yaml.AliasNode {
+		target := node.Alias
+		if replacement, ok := r.replaced[target]; ok {
+			target = replacement
+		}
+		if copied, ok := r.copies[target]; ok && copied.Anchor != "" {
+			alias := *node
+			alias.Alias = copied
+			alias.Value = copied.Anchor
+			return &alias, nil
+		}
+		if r.expanding[target] {
+			return nil, fmt.Errorf("the alias %s refers to itself", node.Value)
+		}
+		r.expanding[target] = true
+		defer delete(r.expanding, target)
+		expanded, err := r.copy(target)
+		if err != nil {
+			return nil, err
+		}
+		// the expanded copy isn't an anchor for later aliases
+		delete(r.copies, target)
+		expanded.Anchor = ""
+		return expanded, nil
+	}
+
+	copied := *node
+	if copied.Anchor != "" {
+		name := copied.Anchor
+		for suffix := 2; r.anchors[copied.Anchor]; suffix++ {
+			copied.Anchor = fmt.Sprintf("%s%d", name, suffix)
+		}
+		r.anchors[copied.Anchor] = true
+	}
+	r.copies[node] = &copied
+	copied.Content = make([]*yaml.Node, len(node.Content))
+	for index, child := range node.Content {
+		var err error
+		if copied.Content[index], err = r.copy(child); err != nil {
+			return nil, err
+		}
+	}
+	return &copied, nil
+}
@@ -0,0 +1,75 @@
+package patch
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+// WriteChangelog writes every change with its old and new values, so it can
+// be read back by ReadChangelog and applied. Unlike the report, which leaves
+// out values which don't say much, nothing needed to apply the changes is
+// left out. Paths which the dotted form can't hold are written in the
+// bracket-quoted form.
+func WriteChangelog(changes diff.ChangeLogEntries, w io.Writer) error {
+	entries := make(diff.ChangeLogEntries, 0, len(changes))
+	for _, change := range changes {
+		if change.ChangeType == diff.NoChange {
+			continue
+		}
+		// the values may hold aliases to anchors elsewhere in their documents
+		if change.From != nil {
+			change.From = copyNode(change.From, true, map[*yaml.Node]bool{})
+		}
+		if change.To != nil {
+			change.To = copyNode(change.To, true, map[*yaml.Node]bool{})
+		}
+		entries = append(entries, change)
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(entries); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// ReadChangelog reads the changes written by WriteChangelog
+func ReadChangelog(r io.Reader) (diff.ChangeLogEntries, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if err == io.EOF {
+			return diff.ChangeLogEntries{}, nil
+		}
+		return nil, err
+	}
+	list := content(&doc)
+	if list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("line %d: expected a list of changes", list.Line)
+	}
+	changes := make(diff.ChangeLogEntries, len(list.Content))
+	for index, entry := range list.Content {
+		// this version of the yaml package can't decode into node fields, so
+		// the values are taken out and set afterwards
+		var from, to *yaml.Node
+		fields := &yaml.Node{Kind: yaml.MappingNode}
+		for pair := 0; pair+1 < len(entry.Content); pair += 2 {
+			switch entry.Content[pair].Value {
+			case "from":
+				from = entry.Content[pair+1]
+			case "to":
+				to = entry.Content[pair+1]
+			default:
+				fields.Content = append(fields.Content, entry.Content[pair], entry.Content[pair+1])
+			}
+		}
+		if err := fields.Decode(&changes[index]); err != nil {
+			return nil, err
+		}
+		changes[index].From = from
+		changes[index].To = to
+	}
+	return changes, nil
+}
@@ -0,0 +1,224 @@
+package patch
+
+import (
+	"fmt"
+
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+// patcher finds nodes by their paths while the stream is being changed. The
+// diff sees through aliases and merge keys, so they are expanded into copies
+// wherever a change reaches through them, and a node which is aliased gets
+// its aliases expanded before it changes, so they keep the old value.
+type patcher struct {
+	stream *yaml.Node
+	// aliased holds a copy of each aliased node as it was before any changes
+	aliased map[*yaml.Node]*yaml.Node
+	// detached are the aliased nodes whose aliases were expanded
+	detached map[*yaml.Node]bool
+}
+
+func newPatcher(stream *yaml.Node) *patcher {
+	p := &patcher{stream: stream, aliased: map[*yaml.Node]*yaml.Node{}, detached: map[*yaml.Node]bool{}}
+	walk(stream, func(parent *yaml.Node, index int) {
+		if alias := parent.Content[index]; alias.Kind == yaml.AliasNode && alias.Alias != nil {
+			p.aliased[alias.Alias] = nil
+		}
+	})
+	for node := range p.aliased {
+		p.aliased[node] = copyNode(node, false, nil)
+	}
+	return p
+}
+
+// walk calls visit for each child in the tree, without following aliases
+func walk(node *yaml.Node, visit func(parent *yaml.Node, index int)) {
+	for index := range node.Content {
+		visit(node, index)
+		walk(node.Content[index], visit)
+	}
+}
+
+// resolve finds the node at a path within the stream, along with its parent.
+// The first segment is the index of the document.
+func (p *patcher) resolve(segments []diff.PathSegment) (*yaml.Node, *yaml.Node, error) {
+	var parent *yaml.Node
+	node := p.stream
+	for _, segment := range segments {
+		if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+			parent, node = node, node.Content[0]
+			p.touch(node)
+		}
+		index, err := p.childIndex(node, segment)
+		if err != nil {
+			return nil, nil, err
+		}
+		child := node.Content[index]
+		if child.Kind == yaml.AliasNode && child.Alias != nil {
+			child = p.copyOf(child.Alias)
+			node.Content[index] = child
+		}
+		parent, node = node, child
+		p.touch(node)
+	}
+	return parent, node, nil
+}
+
+// childIndex finds the index in the node's content of the child at a segment
+func (p *patcher) childIndex(node *yaml.Node, segment diff.PathSegment) (int, error) {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		if !segment.IsIndex {
+			return 0, fmt.Errorf("expected a mapping for key %q but found a sequence", segment.Key)
+		}
+		if segment.Index >= len(node.Content) {
+			return 0, fmt.Errorf("index %d is past the end of the sequence", segment.Index)
+		}
+		return segment.Index, nil
+	case yaml.MappingNode:
+		if segment.IsIndex {
+			return 0, fmt.Errorf("expected a sequence for index %d but found a mapping", segment.Index)
+		}
+		index := keyIndex(node, segment.Key)
+		if index < 0 && p.expandMerges(node) {
+			index = keyIndex(node, segment.Key)
+		}
+		if index < 0 {
+			return 0, fmt.Errorf("there's no key %q", segment.Key)
+		}
+		return index + 1, nil
+	}
+	return 0, fmt.Errorf("can't find %s in a %s", segmentName(segment), diff.KindLabels[node.Kind])
+}
+
+// keyIndex finds the index of a key in a mapping's content, or -1
+func keyIndex(mapping *yaml.Node, key string) int {
+	for index := 0; index+1 < len(mapping.Content); index += 2 {
+		if keyNode := mapping.Content[index]; !isMergeKey(keyNode) && keyNode.Value == key {
+			return index
+		}
+	}
+	return -1
+}
+
+func isMergeKey(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!merge"
+}
+
+// expandMerges replaces the << merge keys of a mapping with copies of the
+// keys they merge in, as the diff sees them. Explicit keys override merged
+// ones, and earlier merges override later ones. It reports whether there
+// were any.
+func (p *patcher) expandMerges(mapping *yaml.Node) bool {
+	explicit := []*yaml.Node{}
+	merged := []*yaml.Node{}
+	at := -1
+	for index := 0; index+1 < len(mapping.Content); index += 2 {
+		keyNode, valueNode := mapping.Content[index], mapping.Content[index+1]
+		if !isMergeKey(keyNode) {
+			explicit = append(explicit, keyNode, valueNode)
+			continue
+		}
+		if at < 0 {
+			at = len(explicit)
+		}
+		merged = append(merged, p.mergedPairs(valueNode)...)
+	}
+	if at < 0 {
+		return false
+	}
+	seen := map[string]bool{}
+	for index := 0; index < len(explicit); index += 2 {
+		seen[explicit[index].Value] = true
+	}
+	pairs := []*yaml.Node{}
+	for index := 0; index+1 < len(merged); index += 2 {
+		if !seen[merged[index].Value] {
+			seen[merged[index].Value] = true
+			pairs = append(pairs, merged[index], merged[index+1])
+		}
+	}
+	content := append([]*yaml.Node{}, explicit[:at]...)
+	content = append(content, pairs...)
+	mapping.Content = append(content, explicit[at:]...)
+	return true
+}
+
+// mergedPairs returns the keys and values a merge key merges in
+func (p *patcher) mergedPairs(node *yaml.Node) []*yaml.Node {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = p.copyOf(node.Alias)
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		p.expandMerges(node)
+		return node.Content
+	case yaml.SequenceNode:
+		pairs := []*yaml.Node{}
+		for _, each := range node.Content {
+			pairs = append(pairs, p.mergedPairs(each)...)
+		}
+		return pairs
+	}
+	return nil
+}
+
+// touch expands the aliases of a node which is about to change, or to have
+// something beneath it change, so they keep its old value
+func (p *patcher) touch(node *yaml.Node) {
+	if _, ok := p.aliased[node]; !ok || p.detached[node] {
+		return
+	}
+	p.detached[node] = true
+	expand := func(parent *yaml.Node, index int) {
+		if alias := parent.Content[index]; alias.Kind == yaml.AliasNode && alias.Alias == node {
+			parent.Content[index] = p.copyOf(node)
+		}
+	}
+	walk(p.stream, expand)
+	for _, original := range p.aliased {
+		walk(original, expand)
+	}
+}
+
+// copyOf returns a new copy of an aliased node as it was before any changes
+func (p *patcher) copyOf(node *yaml.Node) *yaml.Node {
+	if original, ok := p.aliased[node]; ok && original != nil {
+		return copyNode(original, false, nil)
+	}
+	return copyNode(node, false, nil)
+}
+
+// newNode copies a node from the new document. Its aliases may refer to
+// anchors which aren't in the patched document, so they're expanded.
+func (p *patcher) newNode(node *yaml.Node, document bool) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	copied := copyNode(node, true, map[*yaml.Node]bool{})
+	if document && copied.Kind != yaml.DocumentNode {
+		return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{copied}}
+	}
+	return copied
+}
+
+// copyNode deep copies a node without its anchors, which belong to the
+// original. Aliases are copied as they are unless expanding, when they're
+// replaced by copies of the nodes they refer to.
+func copyNode(node *yaml.Node, expanding bool, expanded map[*yaml.Node]bool) *yaml.Node {
+	if expanding && node.Kind == yaml.AliasNode && node.Alias != nil && !expanded[node.Alias] {
+		expanded[node.Alias] = true
+		defer delete(expanded, node.Alias)
+		return copyNode(node.Alias, expanding, expanded)
+	}
+	copied := *node
+	copied.Anchor = ""
+	if node.Content != nil {
+		copied.Content = make([]*yaml.Node, len(node.Content))
+		for index, child := range node.Content {
+			copied.Content[index] = copyNode(child, expanding, expanded)
+		}
+	}
+	return &copied
+}
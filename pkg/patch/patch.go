@@ -0,0 +1,335 @@
+// Package patch applies the changes listed by a diff to a yaml document, so
+// that the old document becomes the new one.
+package patch
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+// Apply makes the changes to the document in place. The changes should be
+// the ones listed between the document and some new version of it, with the
+// From and To values which the diff sets. Comments and the formatting of
+// the untouched parts of the document are kept. The From values are nodes of
+// the old document, so they change along with it.
+//
+// Comment and style changes are only reported, so they aren't applied, and
+// the line diffs of multi-line values are ignored in favour of their new
+// values.
+func Apply(doc *yaml.Node, changes diff.ChangeLogEntries) error {
+	root := doc
+	if doc.Kind != yaml.DocumentNode {
+		// the paths of a bare node start at the node, as for a document's
+		// content
+		root = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{doc}}
+	}
+	docs, err := ApplyStream([]*yaml.Node{root}, changes)
+	if err != nil {
+		return err
+	}
+	if len(docs) != 1 {
+		return fmt.Errorf("the changes are for a stream of %d documents", len(docs))
+	}
+	if doc.Kind != yaml.DocumentNode && len(docs[0].Content) > 0 && docs[0].Content[0] != doc {
+		// the whole node changed kind
+		*doc = *docs[0].Content[0]
+	}
+	return nil
+}
+
+// ApplyStream makes the changes to the documents of a multi-document
+// stream, like Apply, and returns the documents of the new stream. The
+// documents are changed in place, though some may have been added, removed
+// or moved.
+func ApplyStream(docs []*yaml.Node, changes diff.ChangeLogEntries) ([]*yaml.Node, error) {
+	stream := &yaml.Node{Kind: yaml.SequenceNode, Content: append([]*yaml.Node{}, docs...)}
+	p := newPatcher(stream)
+
+	// find what was deleted or moved away while the old paths still hold
+	removals := []removal{}
+	for _, change := range changes {
+		if change.ChangeType != diff.Deleted && change.ChangeType != diff.Moved {
+			continue
+		}
+		parent, node, err := p.resolve(fullPath(change.Path))
+		if err != nil {
+			return nil, changeError(change, err)
+		}
+		removals = append(removals, removal{change: change, parent: parent, node: node})
+	}
+	for _, each := range removals {
+		if err := removeChild(each.parent, each.node); err != nil {
+			return nil, changeError(each.change, err)
+		}
+	}
+
+	// then build up the new document from the top down, so each change
+	// finds its parent where the new paths say
+	byDepth := map[int][]insertion{}
+	for _, each := range removals {
+		if each.change.ChangeType == diff.Moved {
+			depth := len(fullPath(each.change.Path))
+			byDepth[depth] = append(byDepth[depth], insertion{change: each.change, parent: each.parent, node: each.node})
+		}
+	}
+	others := map[int]diff.ChangeLogEntries{}
+	maxDepth := 0
+	for _, change := range changes {
+		depth := len(fullPath(change.Path))
+		switch change.ChangeType {
+		case diff.Added:
+			byDepth[depth] = append(byDepth[depth], insertion{change: change})
+		case diff.Changed, diff.TypeChanged, diff.KindChanged, diff.Renamed:
+			others[depth] = append(others[depth], change)
+		default:
+			continue
+		}
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+	for depth := range byDepth {
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+
+	for depth := 1; depth <= maxDepth; depth++ {
+		if err := p.insert(byDepth[depth]); err != nil {
+			return nil, err
+		}
+		if err := p.rename(others[depth]); err != nil {
+			return nil, err
+		}
+		for _, change := range others[depth] {
+			if change.ChangeType == diff.Renamed {
+				continue
+			}
+			if err := p.replace(change); err != nil {
+				return nil, changeError(change, err)
+			}
+		}
+	}
+	return stream.Content, nil
+}
+
+// removal is a node which was deleted or moved away
+type removal struct {
+	change diff.ChangeLogEntry
+	parent *yaml.Node
+	node   *yaml.Node
+}
+
+// insertion is a node which was added, or moved within its parent when the
+// parent is set
+type insertion struct {
+	change diff.ChangeLogEntry
+	parent *yaml.Node
+	node   *yaml.Node
+}
+
+// fullPath returns the path within the stream, starting with the document
+func fullPath(path diff.Path) []diff.PathSegment {
+	doc := 0
+	if path.Document != nil {
+		doc = *path.Document
+	}
+	return append([]diff.PathSegment{diff.IndexSegment(doc)}, path.Segments...)
+}
+
+func changeError(change diff.ChangeLogEntry, err error) error {
+	return fmt.Errorf("can't apply %s change at %s: %w", change.ChangeType, change.Path, err)
+}
+
+// insert adds the nodes which are new to their parents, or moved within them.
+// The items of a sequence are inserted from the lowest index up, so each
+// lands where the new document has it.
+func (p *patcher) insert(insertions []insertion) error {
+	type target struct {
+		parent *yaml.Node
+		items  []insertion
+	}
+	targets := []*target{}
+	byParent := map[*yaml.Node]*target{}
+	for _, each := range insertions {
+		if each.parent == nil {
+			segments := fullPath(each.change.Path)
+			_, parent, err := p.resolve(segments[:len(segments)-1])
+			if err != nil {
+				return changeError(each.change, err)
+			}
+			each.parent = content(parent)
+			each.node = p.newNode(each.change.To, len(segments) == 1)
+			if each.node == nil {
+				return changeError(each.change, fmt.Errorf("the new value is missing"))
+			}
+		}
+		if byParent[each.parent] == nil {
+			byParent[each.parent] = &target{parent: each.parent}
+			targets = append(targets, byParent[each.parent])
+		}
+		byParent[each.parent].items = append(byParent[each.parent].items, each)
+	}
+
+	for _, target := range targets {
+		if target.parent.Kind == yaml.MappingNode {
+			for _, each := range target.items {
+				segments := fullPath(each.change.Path)
+				last := segments[len(segments)-1]
+				if last.IsIndex {
+					return changeError(each.change, fmt.Errorf("expected a sequence for index %d but found a mapping", last.Index))
+				}
+				p.expandMerges(target.parent)
+				target.parent.Content = append(target.parent.Content, keyNode(last.Key), each.node)
+			}
+			continue
+		}
+		if target.parent.Kind != yaml.SequenceNode {
+			return changeError(target.items[0].change, fmt.Errorf("the parent is a %s", diff.KindLabels[target.parent.Kind]))
+		}
+		sort.SliceStable(target.items, func(i, j int) bool {
+			return insertIndex(target.items[i].change) < insertIndex(target.items[j].change)
+		})
+		for _, each := range target.items {
+			index := insertIndex(each.change)
+			if index > len(target.parent.Content) {
+				index = len(target.parent.Content)
+			}
+			items := append(target.parent.Content, nil)
+			copy(items[index+1:], items[index:])
+			items[index] = each.node
+			target.parent.Content = items
+		}
+	}
+	return nil
+}
+
+// insertIndex is where an added or moved item goes in the new sequence
+func insertIndex(change diff.ChangeLogEntry) int {
+	if change.ChangeType == diff.Moved && change.ToIndex != nil {
+		return *change.ToIndex
+	}
+	segments := fullPath(change.Path)
+	return segments[len(segments)-1].Index
+}
+
+// rename renames the keys of mappings. The keys are all found before any
+// are renamed, in case they swapped names.
+func (p *patcher) rename(changes diff.ChangeLogEntries) error {
+	type renaming struct {
+		key *yaml.Node
+		to  string
+	}
+	renamings := []renaming{}
+	for _, change := range changes {
+		if change.ChangeType != diff.Renamed {
+			continue
+		}
+		segments := fullPath(change.Path)
+		_, parent, err := p.resolve(segments[:len(segments)-1])
+		if err != nil {
+			return changeError(change, err)
+		}
+		mapping := content(parent)
+		if mapping.Kind != yaml.MappingNode {
+			return changeError(change, fmt.Errorf("the parent is a %s", diff.KindLabels[mapping.Kind]))
+		}
+		p.expandMerges(mapping)
+		index := keyIndex(mapping, change.FromKey)
+		if index < 0 {
+			return changeError(change, fmt.Errorf("there's no key %q", change.FromKey))
+		}
+		renamings = append(renamings, renaming{key: mapping.Content[index], to: change.ToKey})
+	}
+	for _, each := range renamings {
+		each.key.Value = each.to
+	}
+	return nil
+}
+
+// replace changes a node in place
+func (p *patcher) replace(change diff.ChangeLogEntry) error {
+	parent, node, err := p.resolve(fullPath(change.Path))
+	if err != nil {
+		return err
+	}
+	if node.Kind == yaml.DocumentNode {
+		// the top level node of the document changed
+		if len(node.Content) == 0 {
+			return fmt.Errorf("the document is empty")
+		}
+		parent, node = node, node.Content[0]
+	}
+	if change.To == nil {
+		return fmt.Errorf("the new value is missing")
+	}
+	switch change.ChangeType {
+	case diff.KindChanged:
+		replacement := p.newNode(change.To, false)
+		if replacement.HeadComment == "" && replacement.LineComment == "" && replacement.FootComment == "" {
+			replacement.HeadComment = node.HeadComment
+			replacement.LineComment = node.LineComment
+			replacement.FootComment = node.FootComment
+		}
+		for index, child := range parent.Content {
+			if child == node {
+				parent.Content[index] = replacement
+				return nil
+			}
+		}
+		return fmt.Errorf("the node isn't in its parent")
+	default:
+		if node.Kind != yaml.ScalarNode && node.Kind != yaml.AliasNode {
+			return fmt.Errorf("expected a scalar but found a %s", diff.KindLabels[node.Kind])
+		}
+		node.Value = change.To.Value
+		node.Tag = change.To.Tag
+		node.Style = change.To.Style
+	}
+	return nil
+}
+
+// removeChild removes a node from a sequence, or its key and value from a
+// mapping
+func removeChild(parent, node *yaml.Node) error {
+	switch parent.Kind {
+	case yaml.MappingNode:
+		for index := 1; index < len(parent.Content); index += 2 {
+			if parent.Content[index] == node {
+				parent.Content = append(parent.Content[:index-1], parent.Content[index+1:]...)
+				return nil
+			}
+		}
+	case yaml.SequenceNode:
+		for index, child := range parent.Content {
+			if child == node {
+				parent.Content = append(parent.Content[:index], parent.Content[index+1:]...)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("the node isn't in its parent")
+}
+
+// content returns the top level node of a document, or the node itself
+func content(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		return node.Content[0]
+	}
+	return node
+}
+
+func keyNode(key string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+}
+
+// segmentName describes a path segment in errors
+func segmentName(segment diff.PathSegment) string {
+	if segment.IsIndex {
+		return fmt.Sprintf("index %d", segment.Index)
+	}
+	return fmt.Sprintf("key %q", segment.Key)
+}
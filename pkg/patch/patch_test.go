@@ -0,0 +1,159 @@
+package patch_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/corbym/gocrest/has"
+	"github.com/corbym/gocrest/is"
+	"github.com/corbym/gocrest/then"
+	"github.com/stretchr/testify/require"
+	"github.com/wjase/diffyaml/pkg/diff"
+	"github.com/wjase/diffyaml/pkg/internal/fixtures"
+	"github.com/wjase/diffyaml/pkg/patch"
+	"gopkg.in/yaml.v3"
+)
+
+var assertThat = then.AssertThat
+
+// TestPatchFiles applies the changes between each pair of fixtures to the
+// old one and checks there are none left between the result and the new one
+func TestPatchFiles(t *testing.T) {
+	fixtures.ForEach(t, fixtures.Suites, func(t *testing.T, docs1, docs2 []*yaml.Node, changes diff.ChangeLogEntries, options []diff.Option) {
+		// the changes survive being written out and read back
+		changelog := bytes.Buffer{}
+		require.NoError(t, patch.WriteChangelog(changes, &changelog))
+		readChanges, err := patch.ReadChangelog(&changelog)
+		require.NoError(t, err)
+
+		patched, err := patch.ApplyStream(docs1, readChanges)
+		require.NoError(t, err)
+
+		remaining, err := diff.GetYamlStreamChanges(reparse(t, patched), docs2, options...)
+		require.NoError(t, err)
+		assertThat(t, remaining, has.Length(0))
+	})
+}
+
+// TestInvertFiles applies the inverse of the changes between each pair of
+// fixtures to the new one, which should turn it back into the old one
+func TestInvertFiles(t *testing.T) {
+	fixtures.ForEach(t, fixtures.Suites, func(t *testing.T, docs1, docs2 []*yaml.Node, changes diff.ChangeLogEntries, options []diff.Option) {
+		// the changes hold nodes of the documents, so copies are patched
+		patched, err := patch.ApplyStream(reparse(t, docs1), changes)
+		require.NoError(t, err)
+		unpatched, err := patch.ApplyStream(reparse(t, patched), changes.Invert())
+		require.NoError(t, err)
+
+		remaining, err := diff.GetYamlStreamChanges(reparse(t, unpatched), docs1, options...)
+		require.NoError(t, err)
+		assertThat(t, remaining, has.Length(0))
+
+		// the new document doesn't need patching first
+		unpatched, err = patch.ApplyStream(reparse(t, docs2), changes.Invert())
+		require.NoError(t, err)
+		remaining, err = diff.GetYamlStreamChanges(reparse(t, unpatched), docs1, options...)
+		require.NoError(t, err)
+		assertThat(t, remaining, has.Length(0))
+	})
+}
+
+func TestApplyKeepsAliasesOfChangedAnchors(t *testing.T) {
+	old := parse(t, "base: &base\n  port: 80\nservice:\n  <<: *base\n  name: web\nother: *base\n")
+	new := parse(t, "base:\n  port: 8080\nservice:\n  port: 80\n  name: api\nother:\n  port: 80\n")
+
+	changes, err := diff.GetYamlNodeChanges(old, new)
+	require.NoError(t, err)
+	require.NoError(t, patch.Apply(old, changes))
+
+	remaining, err := diff.GetYamlNodeChanges(reparse(t, []*yaml.Node{old})[0], new)
+	require.NoError(t, err)
+	assertThat(t, remaining, has.Length(0))
+}
+
+func TestApplyKeepsComments(t *testing.T) {
+	old := parse(t, "# settings\nname: web # the name\nports:\n  - 80\n  - 443\n")
+	new := parse(t, "name: api\nports:\n  - 443\n  - 8443\n")
+
+	changes, err := diff.GetYamlNodeChanges(old, new)
+	require.NoError(t, err)
+	require.NoError(t, patch.Apply(old, changes))
+
+	out, err := yaml.Marshal(old)
+	require.NoError(t, err)
+	assertThat(t, string(out), is.EqualTo("# settings\nname: api # the name\nports:\n    - 443\n    - 8443\n"))
+}
+
+// TestApplySequenceItemsOfOtherKinds checks items which aren't scalars are
+// only reported as type changes when they are, so the changes apply
+func TestApplySequenceItemsOfOtherKinds(t *testing.T) {
+	tests := []struct{ from, to string }{
+		{from: "[1, {a: 1}]", to: "[1, [x]]"},
+		{from: "k: [{a: 1}, 2]", to: "k: [2, {b: 1}]"},
+		{from: "k: [[1], {a: 1}, x]", to: "k: [{b: 2}, x, [2]]"},
+	}
+	for _, test := range tests {
+		old, new := parse(t, test.from), parse(t, test.to)
+		changes, err := diff.GetYamlNodeChanges(old, new)
+		require.NoError(t, err)
+		for _, change := range changes {
+			if change.ChangeType == diff.TypeChanged {
+				assertThat(t, change.From.Kind, is.EqualTo(yaml.ScalarNode))
+			}
+		}
+
+		patched := reparse(t, []*yaml.Node{old})[0]
+		require.NoError(t, patch.Apply(patched, changes))
+		remaining, err := diff.GetYamlNodeChanges(reparse(t, []*yaml.Node{patched})[0], new)
+		require.NoError(t, err)
+		assertThat(t, remaining, has.Length(0))
+
+		unpatched := reparse(t, []*yaml.Node{new})[0]
+		require.NoError(t, patch.Apply(unpatched, changes.Invert()))
+		remaining, err = diff.GetYamlNodeChanges(reparse(t, []*yaml.Node{unpatched})[0], old)
+		require.NoError(t, err)
+		assertThat(t, remaining, has.Length(0))
+	}
+}
+
+func TestApplyReportsMissingPaths(t *testing.T) {
+	doc := parse(t, "a: 1\n")
+	changes := diff.ChangeLogEntries{{
+		Path:       diff.Path{Segments: []diff.PathSegment{diff.KeySegment("b")}},
+		ChangeType: diff.Deleted,
+	}}
+
+	err := patch.Apply(doc, changes)
+	assertThat(t, err, is.Not(is.Nil()))
+	assertThat(t, err.Error(), is.EqualTo(`can't apply deleted change at doc.b: there's no key "b"`))
+}
+
+func parse(t *testing.T, text string) *yaml.Node {
+	var doc yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte(text), &doc))
+	return &doc
+}
+
+// reparse writes out the documents and reads them back, to check the
+// patched documents are valid yaml
+func reparse(t *testing.T, docs []*yaml.Node) []*yaml.Node {
+	buffer := bytes.Buffer{}
+	encoder := yaml.NewEncoder(&buffer)
+	for _, doc := range docs {
+		require.NoError(t, encoder.Encode(doc))
+	}
+	require.NoError(t, encoder.Close())
+
+	decoder := yaml.NewDecoder(&buffer)
+	reparsed := []*yaml.Node{}
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			return reparsed
+		}
+		require.NoError(t, err)
+		reparsed = append(reparsed, &doc)
+	}
+}
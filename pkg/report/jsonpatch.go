@@ -0,0 +1,472 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+// JSONPatchOperation is one operation of an RFC 6902 JSON Patch
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	From  string          `json:"from,omitempty"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// WriteJSONPatch writes the changes as an RFC 6902 JSON Patch, like
+// JSONPatch
+func WriteJSONPatch(changes []diff.ChangeLogEntry, w io.Writer, tests bool) error {
+	operations, err := JSONPatch(changes, tests)
+	if err != nil {
+		return err
+	}
+	patch, err := json.MarshalIndent(operations, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(patch, '\n'))
+	return err
+}
+
+// JSONPatch converts the changes to the operations of an RFC 6902 JSON
+// Patch, which turn the old document into the new one when applied in
+// order. The indexes of each operation allow for the operations before it.
+// With tests, each value which is removed, replaced, moved or renamed
+// unchanged is checked by a test operation first.
+//
+// A JSON Patch applies to a single document, so changes between
+// multi-document streams can't be converted. Comment and style changes have
+// no JSON equivalent and are left out.
+func JSONPatch(changes []diff.ChangeLogEntry, tests bool) ([]JSONPatchOperation, error) {
+	j := jsonPatcher{tests: tests, paths: diff.ChangeLogEntries(changes).PathMap(), deleted: map[string]map[int]bool{}}
+	removals := []diff.ChangeLogEntry{}
+	byDepth := map[int][]diff.ChangeLogEntry{}
+	maxDepth := 0
+	for _, change := range changes {
+		if change.ChangeType == diff.NoChange {
+			continue
+		}
+		if change.Path.Document != nil {
+			return nil, fmt.Errorf("a json patch applies to a single document, but %s is in a stream of them", change.Path)
+		}
+		if change.ChangeType == diff.Deleted {
+			removals = append(removals, change)
+			if last, ok := change.Path.Last(); ok && last.IsIndex {
+				parent := change.Path.Parent().Quoted()
+				if j.deleted[parent] == nil {
+					j.deleted[parent] = map[int]bool{}
+				}
+				j.deleted[parent][last.Index] = true
+			}
+			continue
+		}
+		depth := len(change.Path.Segments)
+		byDepth[depth] = append(byDepth[depth], change)
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+
+	// remove from the end of the document back, so each removal leaves the
+	// paths of the ones still to come alone
+	sort.SliceStable(removals, func(i, k int) bool {
+		return comparePaths(removals[i].Path, removals[k].Path) > 0
+	})
+	for _, change := range removals {
+		pointer := change.Path.JSONPointer()
+		if err := j.test(pointer, change.From); err != nil {
+			return nil, err
+		}
+		j.add(JSONPatchOperation{Op: "remove", Path: pointer})
+	}
+
+	// then build up the new document from the top down, so each operation
+	// finds its parent where the new paths say
+	for depth := 0; depth <= maxDepth; depth++ {
+		if err := j.insert(byDepth[depth]); err != nil {
+			return nil, err
+		}
+		if err := j.rename(byDepth[depth]); err != nil {
+			return nil, err
+		}
+		for _, change := range byDepth[depth] {
+			switch change.ChangeType {
+			case diff.Changed, diff.TypeChanged, diff.KindChanged:
+				pointer := change.Path.JSONPointer()
+				if err := j.test(pointer, change.From); err != nil {
+					return nil, err
+				}
+				value, err := jsonValue(change.To)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", change.Path, err)
+				}
+				j.add(JSONPatchOperation{Op: "replace", Path: pointer, Value: value})
+			}
+		}
+	}
+	return j.operations, nil
+}
+
+// jsonPatcher collects the operations of a JSON Patch
+type jsonPatcher struct {
+	operations []JSONPatchOperation
+	tests      bool
+	paths      *diff.PathMap
+	// deleted holds the deleted indexes of each sequence, keyed by its old path
+	deleted map[string]map[int]bool
+}
+
+func (j *jsonPatcher) add(operation JSONPatchOperation) {
+	j.operations = append(j.operations, operation)
+}
+
+// test checks the value at a pointer before it's changed, when asked to
+func (j *jsonPatcher) test(pointer string, node *yaml.Node) error {
+	if !j.tests || node == nil {
+		return nil
+	}
+	value, err := jsonValue(node)
+	if err != nil {
+		return fmt.Errorf("%s: %w", pointer, err)
+	}
+	j.add(JSONPatchOperation{Op: "test", Path: pointer, Value: value})
+	return nil
+}
+
+// sequenceState tracks the items of a sequence while items are moved and
+// added to it. Only the items up to the last one moved are known, and the
+// items after them are added as they're needed.
+type sequenceState struct {
+	// moving holds, for each item, the old index of an item still to be
+	// moved, or -1 for an item in its final order
+	moving []int
+}
+
+// slot returns the index to insert the item with the new index at, which is
+// after the items in their final order before it
+func (s *sequenceState) slot(newIndex int) int {
+	settled := 0
+	for index, oldIndex := range s.moving {
+		if settled == newIndex {
+			return index
+		}
+		if oldIndex < 0 {
+			settled++
+		}
+	}
+	for ; settled < newIndex; settled++ {
+		s.moving = append(s.moving, -1)
+	}
+	return len(s.moving)
+}
+
+func (s *sequenceState) insert(index int) {
+	s.moving = append(s.moving, 0)
+	copy(s.moving[index+1:], s.moving[index:])
+	s.moving[index] = -1
+}
+
+// insert adds the new nodes, and moves the moved items of sequences to their
+// new indexes
+func (j *jsonPatcher) insert(changes []diff.ChangeLogEntry) error {
+	type target struct {
+		parent diff.Path
+		items  []diff.ChangeLogEntry
+	}
+	targets := []*target{}
+	byParent := map[string]*target{}
+	for _, change := range changes {
+		if change.ChangeType != diff.Added && change.ChangeType != diff.Moved {
+			continue
+		}
+		parent := change.Path.Parent()
+		if change.ChangeType == diff.Moved {
+			// moves are reported under the old path
+			newParent, ok := j.paths.ToNew(parent)
+			if !ok {
+				return fmt.Errorf("%s: the sequence the item moved within was deleted", change.Path)
+			}
+			parent = newParent
+		}
+		key := parent.Quoted()
+		if byParent[key] == nil {
+			byParent[key] = &target{parent: parent}
+			targets = append(targets, byParent[key])
+		}
+		byParent[key].items = append(byParent[key].items, change)
+	}
+
+	for _, target := range targets {
+		last, _ := target.items[0].Path.Last()
+		if !last.IsIndex {
+			for _, change := range target.items {
+				value, err := jsonValue(change.To)
+				if err != nil {
+					return fmt.Errorf("%s: %w", change.Path, err)
+				}
+				j.add(JSONPatchOperation{Op: "add", Path: change.Path.JSONPointer(), Value: value})
+			}
+			continue
+		}
+		if err := j.insertItems(target.parent, target.items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertItems adds and moves the items of a sequence, from the lowest new
+// index up
+func (j *jsonPatcher) insertItems(parent diff.Path, items []diff.ChangeLogEntry) error {
+	sort.SliceStable(items, func(i, k int) bool {
+		return newIndex(items[i]) < newIndex(items[k])
+	})
+	state := sequenceState{}
+	if oldParent, ok := j.paths.ToOld(parent); ok {
+		// the items which will move are where they were, less the deleted ones
+		maxOld := -1
+		for _, item := range items {
+			if item.ChangeType == diff.Moved && *item.FromIndex > maxOld {
+				maxOld = *item.FromIndex
+			}
+		}
+		deleted := j.deleted[oldParent.Quoted()]
+		for index := 0; index <= maxOld; index++ {
+			if !deleted[index] {
+				state.moving = append(state.moving, -1)
+			}
+		}
+		for _, item := range items {
+			if item.ChangeType == diff.Moved {
+				current := *item.FromIndex
+				for index := range deleted {
+					if index < *item.FromIndex {
+						current--
+					}
+				}
+				state.moving[current] = *item.FromIndex
+			}
+		}
+	}
+
+	for _, item := range items {
+		if item.ChangeType == diff.Added {
+			value, err := jsonValue(item.To)
+			if err != nil {
+				return fmt.Errorf("%s: %w", item.Path, err)
+			}
+			index := state.slot(newIndex(item))
+			state.insert(index)
+			j.add(JSONPatchOperation{Op: "add", Path: parent.Child(diff.IndexSegment(index)).JSONPointer(), Value: value})
+			continue
+		}
+		current := -1
+		for index, oldIndex := range state.moving {
+			if oldIndex == *item.FromIndex {
+				current = index
+			}
+		}
+		if current < 0 {
+			return fmt.Errorf("%s: the moved item wasn't found", item.Path)
+		}
+		state.moving = append(state.moving[:current], state.moving[current+1:]...)
+		index := state.slot(newIndex(item))
+		state.insert(index)
+		if index == current {
+			continue
+		}
+		from := parent.Child(diff.IndexSegment(current)).JSONPointer()
+		if unchanged(item.From, item.To) {
+			// the contents of a modified item may already have changed
+			if err := j.test(from, item.From); err != nil {
+				return err
+			}
+		}
+		j.add(JSONPatchOperation{Op: "move", From: from, Path: parent.Child(diff.IndexSegment(index)).JSONPointer()})
+	}
+	return nil
+}
+
+// newIndex is the index of an added or moved item in the new sequence
+func newIndex(change diff.ChangeLogEntry) int {
+	if change.ChangeType == diff.Moved {
+		return *change.ToIndex
+	}
+	last, _ := change.Path.Last()
+	return last.Index
+}
+
+// rename moves the values of renamed keys to their new keys. When keys swap
+// names, one is moved out of the way first.
+func (j *jsonPatcher) rename(changes []diff.ChangeLogEntry) error {
+	pending := []diff.ChangeLogEntry{}
+	for _, change := range changes {
+		if change.ChangeType == diff.Renamed {
+			pending = append(pending, change)
+		}
+	}
+	for len(pending) > 0 {
+		next := -1
+		for index, change := range pending {
+			if !renamesFrom(pending, change.Path.Parent(), change.ToKey) {
+				next = index
+				break
+			}
+		}
+		if next < 0 {
+			// they form a cycle, so park one under a key none of them use
+			change := &pending[0]
+			parked := change.FromKey + "~"
+			for renamesFrom(pending, change.Path.Parent(), parked) || renamesTo(pending, change.Path.Parent(), parked) {
+				parked += "~"
+			}
+			parent := change.Path.Parent()
+			j.add(JSONPatchOperation{
+				Op:   "move",
+				From: parent.Child(diff.KeySegment(change.FromKey)).JSONPointer(),
+				Path: parent.Child(diff.KeySegment(parked)).JSONPointer(),
+			})
+			change.FromKey = parked
+			continue
+		}
+		change := pending[next]
+		from := change.Path.Parent().Child(diff.KeySegment(change.FromKey)).JSONPointer()
+		if unchanged(change.From, change.To) {
+			if err := j.test(from, change.From); err != nil {
+				return err
+			}
+		}
+		j.add(JSONPatchOperation{Op: "move", From: from, Path: change.Path.JSONPointer()})
+		pending = append(pending[:next], pending[next+1:]...)
+	}
+	return nil
+}
+
+func renamesFrom(renames []diff.ChangeLogEntry, parent diff.Path, key string) bool {
+	for _, change := range renames {
+		if change.FromKey == key && change.Path.Parent().Equal(parent) {
+			return true
+		}
+	}
+	return false
+}
+
+func renamesTo(renames []diff.ChangeLogEntry, parent diff.Path, key string) bool {
+	for _, change := range renames {
+		if change.ToKey == key && change.Path.Parent().Equal(parent) {
+			return true
+		}
+	}
+	return false
+}
+
+// unchanged reports whether the old and new values of a move or rename are
+// the same, so the old value is still there to test
+func unchanged(from, to *yaml.Node) bool {
+	if from == nil || to == nil {
+		return false
+	}
+	fromValue, err1 := jsonValue(from)
+	toValue, err2 := jsonValue(to)
+	return err1 == nil && err2 == nil && string(fromValue) == string(toValue)
+}
+
+// comparePaths orders paths as their nodes appear in a document
+func comparePaths(path1, path2 diff.Path) int {
+	for index := 0; index < len(path1.Segments) && index < len(path2.Segments); index++ {
+		segment1, segment2 := path1.Segments[index], path2.Segments[index]
+		switch {
+		case segment1.IsIndex && segment2.IsIndex && segment1.Index != segment2.Index:
+			if segment1.Index < segment2.Index {
+				return -1
+			}
+			return 1
+		case !segment1.IsIndex && !segment2.IsIndex && segment1.Key != segment2.Key:
+			return strings.Compare(segment1.Key, segment2.Key)
+		}
+	}
+	return len(path1.Segments) - len(path2.Segments)
+}
+
+// jsonValue converts a yaml node to json. Aliases and merge keys are
+// expanded, and mapping keys become strings.
+func jsonValue(node *yaml.Node) (json.RawMessage, error) {
+	value, err := plainValue(node, map[*yaml.Node]bool{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+func plainValue(node *yaml.Node, expanding map[*yaml.Node]bool) (interface{}, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return plainValue(node.Content[0], expanding)
+	case yaml.AliasNode:
+		if expanding[node.Alias] {
+			return nil, fmt.Errorf("the alias %s refers to itself", node.Value)
+		}
+		expanding[node.Alias] = true
+		defer delete(expanding, node.Alias)
+		return plainValue(node.Alias, expanding)
+	case yaml.SequenceNode:
+		items := make([]interface{}, len(node.Content))
+		for index, child := range node.Content {
+			item, err := plainValue(child, expanding)
+			if err != nil {
+				return nil, err
+			}
+			items[index] = item
+		}
+		return items, nil
+	case yaml.MappingNode:
+		values := map[string]interface{}{}
+		merges := []*yaml.Node{}
+		for index := 0; index+1 < len(node.Content); index += 2 {
+			key, child := node.Content[index], node.Content[index+1]
+			if key.Kind == yaml.ScalarNode && key.ShortTag() == "!!merge" {
+				merges = append(merges, child)
+				continue
+			}
+			value, err := plainValue(child, expanding)
+			if err != nil {
+				return nil, err
+			}
+			values[key.Value] = value
+		}
+		// explicit keys override merged ones, and earlier merges override later ones
+		for _, merge := range merges {
+			merged, err := plainValue(merge, expanding)
+			if err != nil {
+				return nil, err
+			}
+			mappings, ok := merged.([]interface{})
+			if !ok {
+				mappings = []interface{}{merged}
+			}
+			for _, mapping := range mappings {
+				fields, _ := mapping.(map[string]interface{})
+				for key, value := range fields {
+					if _, ok := values[key]; !ok {
+						values[key] = value
+					}
+				}
+			}
+		}
+		return values, nil
+	}
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,281 @@
+package report_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/corbym/gocrest/has"
+	"github.com/corbym/gocrest/is"
+	"github.com/corbym/gocrest/then"
+	"github.com/stretchr/testify/require"
+	"github.com/wjase/diffyaml/pkg/diff"
+	"github.com/wjase/diffyaml/pkg/internal/fixtures"
+	"github.com/wjase/diffyaml/pkg/report"
+	"gopkg.in/yaml.v3"
+)
+
+var assertThat = then.AssertThat
+
+// jsonSuites are the fixtures whose changes can be checked as json. The
+// semantic ones are left out, as json tells apart values such as yes and
+// true which the semantic diff doesn't report.
+func jsonSuites() []fixtures.Suite {
+	suites := []fixtures.Suite{}
+	for _, suite := range fixtures.Suites {
+		if !suite.Semantic {
+			suites = append(suites, suite)
+		}
+	}
+	return suites
+}
+
+// TestJSONPatchFiles applies the JSON Patch of the changes between each pair
+// of fixtures to the old one, and checks the result is the new one
+func TestJSONPatchFiles(t *testing.T) {
+	fixtures.ForEach(t, jsonSuites(), func(t *testing.T, docs1, docs2 []*yaml.Node, changes diff.ChangeLogEntries, options []diff.Option) {
+		for _, tests := range []bool{false, true} {
+			operations, err := report.JSONPatch(changes, tests)
+			if len(docs1) > 1 || len(docs2) > 1 {
+				assertThat(t, err, is.Not(is.Nil()))
+				return
+			}
+			require.NoError(t, err)
+
+			patched, err := applyJSONPatch(toJSON(t, docs1[0]), operations)
+			require.NoError(t, err)
+
+			// compare them as json, which only keeps what json can hold
+			remaining, err := diff.GetYamlNodeChanges(fromJSON(t, patched), fromJSON(t, toJSON(t, docs2[0])), options...)
+			require.NoError(t, err)
+			assertThat(t, remaining, has.Length(0))
+		}
+	})
+}
+
+func TestJSONPatchAdjustsIndexes(t *testing.T) {
+	var old, new yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte("list: [a, b, c, d]\nname: x\n"), &old))
+	require.NoError(t, yaml.Unmarshal([]byte("list: [d, a, e, c]\nname: y\n"), &new))
+	changes, err := diff.GetYamlNodeChanges(&old, &new)
+	require.NoError(t, err)
+
+	buffer := bytes.Buffer{}
+	require.NoError(t, report.WriteJSONPatch(changes, &buffer, true))
+	assertThat(t, buffer.String(), is.EqualTo(`[
+  {
+    "op": "test",
+    "path": "/list/1",
+    "value": "b"
+  },
+  {
+    "op": "remove",
+    "path": "/list/1"
+  },
+  {
+    "op": "test",
+    "path": "/name",
+    "value": "x"
+  },
+  {
+    "op": "replace",
+    "path": "/name",
+    "value": "y"
+  },
+  {
+    "op": "move",
+    "from": "/list/2",
+    "path": "/list/0"
+  },
+  {
+    "op": "add",
+    "path": "/list/2",
+    "value": "e"
+  }
+]
+`))
+}
+
+func TestJSONPatchMovesPastItemsStillToMove(t *testing.T) {
+	var old, new yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte("[x, k1, k2, k3, y]"), &old))
+	require.NoError(t, yaml.Unmarshal([]byte("[k1, y, k2, k3, x]"), &new))
+	changes, err := diff.GetYamlNodeChanges(&old, &new)
+	require.NoError(t, err)
+
+	operations, err := report.JSONPatch(changes, true)
+	require.NoError(t, err)
+	patched, err := applyJSONPatch(toJSON(t, &old), operations)
+	require.NoError(t, err)
+	assertThat(t, patched, is.EqualTo(toJSON(t, &new)))
+}
+
+func toJSON(t *testing.T, node *yaml.Node) interface{} {
+	var text bytes.Buffer
+	require.NoError(t, report.WriteJSONPatch([]diff.ChangeLogEntry{{ChangeType: diff.Changed, To: node}}, &text, false))
+	var operations []report.JSONPatchOperation
+	require.NoError(t, json.Unmarshal(text.Bytes(), &operations))
+	var value interface{}
+	require.NoError(t, json.Unmarshal(operations[0].Value, &value))
+	return value
+}
+
+func fromJSON(t *testing.T, value interface{}) *yaml.Node {
+	text, err := json.Marshal(value)
+	require.NoError(t, err)
+	var node yaml.Node
+	require.NoError(t, yaml.Unmarshal(text, &node))
+	return &node
+}
+
+// applyJSONPatch is a minimal RFC 6902 implementation
+func applyJSONPatch(doc interface{}, operations []report.JSONPatchOperation) (interface{}, error) {
+	for _, operation := range operations {
+		var value interface{}
+		if operation.Value != nil {
+			if err := json.Unmarshal(operation.Value, &value); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		switch operation.Op {
+		case "add":
+			doc, err = pointerAdd(doc, operation.Path, value)
+		case "remove":
+			doc, _, err = pointerRemove(doc, operation.Path)
+		case "replace":
+			if doc, _, err = pointerRemove(doc, operation.Path); err == nil {
+				doc, err = pointerAdd(doc, operation.Path, value)
+			}
+		case "move":
+			var moved interface{}
+			if doc, moved, err = pointerRemove(doc, operation.From); err == nil {
+				doc, err = pointerAdd(doc, operation.Path, moved)
+			}
+		case "test":
+			var current interface{}
+			if current, err = pointerGet(doc, operation.Path); err == nil && !reflect.DeepEqual(current, value) {
+				err = fmt.Errorf("test failed at %s: %v isn't %v", operation.Path, current, value)
+			}
+		default:
+			err = fmt.Errorf("unknown op %s", operation.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func pointerTokens(pointer string) []string {
+	if pointer == "" {
+		return nil
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for index, token := range tokens {
+		tokens[index] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens
+}
+
+func pointerGet(doc interface{}, pointer string) (interface{}, error) {
+	for _, token := range pointerTokens(pointer) {
+		switch container := doc.(type) {
+		case map[string]interface{}:
+			child, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("no key %s in %s", token, pointer)
+			}
+			doc = child
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(container) {
+				return nil, fmt.Errorf("bad index %s in %s", token, pointer)
+			}
+			doc = container[index]
+		default:
+			return nil, fmt.Errorf("can't step into %v at %s", doc, pointer)
+		}
+	}
+	return doc, nil
+}
+
+// update replaces the parent of the pointer's target with what change returns
+func update(doc interface{}, pointer string, change func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	tokens := pointerTokens(pointer)
+	if len(tokens) == 0 {
+		return change(nil, "")
+	}
+	parentPointer := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := pointerGet(doc, parentPointer)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := change(parent, tokens[len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	if parentPointer == "" {
+		return changed, nil
+	}
+	doc, _, err = pointerRemove(doc, parentPointer)
+	if err != nil {
+		return nil, err
+	}
+	return pointerAdd(doc, parentPointer, changed)
+}
+
+func pointerAdd(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	return update(doc, pointer, func(parent interface{}, token string) (interface{}, error) {
+		switch container := parent.(type) {
+		case nil:
+			return value, nil
+		case map[string]interface{}:
+			container[token] = value
+			return container, nil
+		case []interface{}:
+			index := len(container)
+			if token != "-" {
+				var err error
+				if index, err = strconv.Atoi(token); err != nil || index < 0 || index > len(container) {
+					return nil, fmt.Errorf("bad index %s in %s", token, pointer)
+				}
+			}
+			items := append(append(append([]interface{}{}, container[:index]...), value), container[index:]...)
+			return items, nil
+		}
+		return nil, fmt.Errorf("can't add to %v at %s", parent, pointer)
+	})
+}
+
+func pointerRemove(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	var removed interface{}
+	doc, err := update(doc, pointer, func(parent interface{}, token string) (interface{}, error) {
+		switch container := parent.(type) {
+		case nil:
+			removed = doc
+			return nil, nil
+		case map[string]interface{}:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("no key %s in %s", token, pointer)
+			}
+			removed = value
+			delete(container, token)
+			return container, nil
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(container) {
+				return nil, fmt.Errorf("bad index %s in %s", token, pointer)
+			}
+			removed = container[index]
+			return append(append([]interface{}{}, container[:index]...), container[index+1:]...), nil
+		}
+		return nil, fmt.Errorf("can't remove from %v at %s", parent, pointer)
+	})
+	return doc, removed, err
+}
@@ -0,0 +1,316 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/wjase/diffyaml/pkg/diff"
+	"gopkg.in/yaml.v3"
+)
+
+// WriteMergePatch writes the changes as a merge patch, like MergePatch, in
+// yaml or, asJSON, in json
+func WriteMergePatch(changes []diff.ChangeLogEntry, new *yaml.Node, w io.Writer, asJSON bool) error {
+	overlay, err := MergePatch(changes, new)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		value, err := plainValue(overlay, map[*yaml.Node]bool{})
+		if err != nil {
+			return err
+		}
+		patch, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(patch, '\n'))
+		return err
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(overlay); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// MergePatch converts the changes to an RFC 7396 JSON Merge Patch, as a yaml
+// overlay document: it's merged into the old document key by key, and a null
+// value deletes a key. A merge patch can't edit a sequence, so each sequence
+// with changes is replaced whole by its value in the new document, which
+// also supplies the values of the other changes.
+//
+// A merge patch can't set a mapping's value to null, and applies to a single
+// document, so such changes can't be converted. Comment and style changes
+// are left out.
+func MergePatch(changes []diff.ChangeLogEntry, new *yaml.Node) (*yaml.Node, error) {
+	paths := diff.ChangeLogEntries(changes).PathMap()
+	m := mergePatcher{new: new, built: map[*yaml.Node]bool{}}
+	edits := []mergeEdit{}
+	for _, change := range changes {
+		if change.ChangeType == diff.NoChange {
+			continue
+		}
+		if change.Path.Document != nil {
+			return nil, fmt.Errorf("a merge patch applies to a single document, but %s is in a stream of them", change.Path)
+		}
+		switch change.ChangeType {
+		case diff.Deleted, diff.Moved:
+			// these are reported under the old path
+			path, inSequence := outerSequence(change.Path)
+			if inSequence {
+				if newPath, ok := paths.ToNew(path); ok {
+					edits = append(edits, mergeEdit{path: newPath})
+				}
+				continue
+			}
+			// otherwise a key was deleted, unless the mapping was
+			if parent, ok := paths.ToNew(path.Parent()); ok {
+				last, _ := path.Last()
+				edits = append(edits, mergeEdit{path: parent.Child(last), delete: true})
+			}
+		case diff.Renamed:
+			path, inSequence := outerSequence(change.Path)
+			edits = append(edits, mergeEdit{path: path})
+			if !inSequence {
+				oldKey := change.Path.Parent().Child(diff.KeySegment(change.FromKey))
+				edits = append(edits, mergeEdit{path: oldKey, delete: true})
+			}
+		case diff.Added, diff.Changed, diff.TypeChanged, diff.KindChanged:
+			path, _ := outerSequence(change.Path)
+			edits = append(edits, mergeEdit{path: path})
+		}
+	}
+
+	// parents come before their children, so the changes within a value
+	// which is set whole are left out
+	sort.SliceStable(edits, func(i, k int) bool {
+		return comparePaths(edits[i].path, edits[k].path) < 0
+	})
+	for _, edit := range edits {
+		if m.covered(edit.path) {
+			continue
+		}
+		if edit.delete {
+			m.place(edit.path, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"})
+			continue
+		}
+		value, err := m.value(edit.path)
+		if err != nil {
+			return nil, err
+		}
+		m.place(edit.path, value)
+	}
+
+	if m.root == nil {
+		m.root = m.mapping()
+	}
+	return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{m.root}}, nil
+}
+
+// mergeEdit is a value to set or a key to delete in a merge patch, by its
+// path in the new document
+type mergeEdit struct {
+	path   diff.Path
+	delete bool
+}
+
+// mergePatcher builds up the overlay of a merge patch
+type mergePatcher struct {
+	root *yaml.Node
+	new  *yaml.Node
+	// built holds the mappings made to hold the overlay's values, as opposed
+	// to the values copied from the new document
+	built map[*yaml.Node]bool
+}
+
+func (m *mergePatcher) mapping() *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	m.built[node] = true
+	return node
+}
+
+// covered reports whether the overlay already has a value at the path, or
+// at one of its parents
+func (m *mergePatcher) covered(path diff.Path) bool {
+	node := m.root
+	for _, segment := range path.Segments {
+		if node == nil {
+			return false
+		}
+		if !m.built[node] {
+			return true
+		}
+		node = fieldOf(node, segment.Key)
+	}
+	return node != nil
+}
+
+// place adds a value to the overlay, with the mappings to hold it
+func (m *mergePatcher) place(path diff.Path, value *yaml.Node) {
+	last, ok := path.Last()
+	if !ok {
+		m.root = value
+		return
+	}
+	if m.root == nil {
+		m.root = m.mapping()
+	}
+	node := m.root
+	for _, segment := range path.Parent().Segments {
+		child := fieldOf(node, segment.Key)
+		if child == nil {
+			child = m.mapping()
+			node.Content = append(node.Content, mergeKey(segment.Key), child)
+		}
+		node = child
+	}
+	node.Content = append(node.Content, mergeKey(last.Key), value)
+}
+
+// value copies the value at a path in the new document for the overlay
+func (m *mergePatcher) value(path diff.Path) (*yaml.Node, error) {
+	node, ok := lookup(m.new, path.Segments)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't in the new document", path)
+	}
+	value, err := copyValue(node, map[*yaml.Node]bool{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	plain, err := plainValue(value, map[*yaml.Node]bool{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, isMapping := plain.(map[string]interface{}); isMapping || len(path.Segments) > 0 {
+		if nullPath, ok := findNull(plain, path); ok {
+			return nil, fmt.Errorf("a merge patch can't set %s to null", nullPath)
+		}
+	}
+	return value, nil
+}
+
+// outerSequence returns the path of the outermost sequence the path is
+// within, which a merge patch has to replace whole, or the path itself
+func outerSequence(path diff.Path) (diff.Path, bool) {
+	for index, segment := range path.Segments {
+		if segment.IsIndex {
+			return diff.Path{Document: path.Document, Segments: path.Segments[:index]}, true
+		}
+	}
+	return path, false
+}
+
+// findNull returns the path of a null value within the mappings of a value,
+// which a merge patch would take as deleting its key
+func findNull(value interface{}, path diff.Path) (diff.Path, bool) {
+	switch value := value.(type) {
+	case nil:
+		return path, true
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if nullPath, ok := findNull(value[key], path.Child(diff.KeySegment(key))); ok {
+				return nullPath, true
+			}
+		}
+	}
+	return path, false
+}
+
+// lookup finds the node at a path, looking through aliases and merge keys
+func lookup(node *yaml.Node, segments []diff.PathSegment) (*yaml.Node, bool) {
+	node = resolved(node)
+	for _, segment := range segments {
+		switch {
+		case segment.IsIndex && node.Kind == yaml.SequenceNode && segment.Index >= 0 && segment.Index < len(node.Content):
+			node = resolved(node.Content[segment.Index])
+		case !segment.IsIndex && node.Kind == yaml.MappingNode:
+			if node = fieldOf(node, segment.Key); node == nil {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// fieldOf returns the value of a key of a mapping, or of the mappings merged
+// into it, or nil if it hasn't the key
+func fieldOf(mapping *yaml.Node, key string) *yaml.Node {
+	merges := []*yaml.Node{}
+	for index := 0; index+1 < len(mapping.Content); index += 2 {
+		keyNode, value := mapping.Content[index], mapping.Content[index+1]
+		if keyNode.Kind == yaml.ScalarNode && keyNode.ShortTag() == "!!merge" {
+			merges = append(merges, resolved(value))
+			continue
+		}
+		if keyNode.Value == key {
+			return resolved(value)
+		}
+	}
+	// earlier merges override later ones
+	for _, merge := range merges {
+		mappings := []*yaml.Node{merge}
+		if merge.Kind == yaml.SequenceNode {
+			mappings = merge.Content
+		}
+		for _, merged := range mappings {
+			if merged = resolved(merged); merged.Kind == yaml.MappingNode {
+				if value := fieldOf(merged, key); value != nil {
+					return value
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resolved follows documents to their content and aliases to their anchors
+func resolved(node *yaml.Node) *yaml.Node {
+	for {
+		switch {
+		case node.Kind == yaml.DocumentNode && len(node.Content) > 0:
+			node = node.Content[0]
+		case node.Kind == yaml.AliasNode:
+			node = node.Alias
+		default:
+			return node
+		}
+	}
+}
+
+// copyValue copies a node for the overlay, expanding its aliases and dropping
+// its anchors, as they may refer outside of it
+func copyValue(node *yaml.Node, expanding map[*yaml.Node]bool) (*yaml.Node, error) {
+	if node.Kind == yaml.AliasNode {
+		if expanding[node.Alias] {
+			return nil, fmt.Errorf("the alias %s refers to itself", node.Value)
+		}
+		expanding[node.Alias] = true
+		defer delete(expanding, node.Alias)
+		return copyValue(node.Alias, expanding)
+	}
+	copied := *node
+	copied.Anchor = ""
+	copied.Content = make([]*yaml.Node, len(node.Content))
+	for index, child := range node.Content {
+		var err error
+		if copied.Content[index], err = copyValue(child, expanding); err != nil {
+			return nil, err
+		}
+	}
+	return &copied, nil
+}
+
+func mergeKey(key string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+}
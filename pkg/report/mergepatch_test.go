@@ -0,0 +1,102 @@
+package report_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/corbym/gocrest/has"
+	"github.com/corbym/gocrest/is"
+	"github.com/stretchr/testify/require"
+	"github.com/wjase/diffyaml/pkg/diff"
+	"github.com/wjase/diffyaml/pkg/internal/fixtures"
+	"github.com/wjase/diffyaml/pkg/report"
+	"gopkg.in/yaml.v3"
+)
+
+// TestMergePatchFiles merges the merge patch of the changes between each
+// pair of fixtures into the old one, and checks the result is the new one
+func TestMergePatchFiles(t *testing.T) {
+	fixtures.ForEach(t, jsonSuites(), func(t *testing.T, docs1, docs2 []*yaml.Node, changes diff.ChangeLogEntries, options []diff.Option) {
+		buffer := bytes.Buffer{}
+		err := report.WriteMergePatch(changes, docs2[len(docs2)-1], &buffer, true)
+		if len(docs1) > 1 || len(docs2) > 1 {
+			assertThat(t, err, is.Not(is.Nil()))
+			return
+		}
+		require.NoError(t, err)
+		var patch interface{}
+		require.NoError(t, json.Unmarshal(buffer.Bytes(), &patch))
+
+		merged := applyMergePatch(toJSON(t, docs1[0]), patch)
+		remaining, err := diff.GetYamlNodeChanges(fromJSON(t, merged), fromJSON(t, toJSON(t, docs2[0])), options...)
+		require.NoError(t, err)
+		assertThat(t, remaining, has.Length(0))
+	})
+}
+
+func TestMergePatchReplacesSequences(t *testing.T) {
+	var old, new yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte("name: web\nports: [80, 443]\nlabels: {app: web, tier: front}\n"), &old))
+	require.NoError(t, yaml.Unmarshal([]byte("name: api\nports: [443, 8443]\nlabels: {app: web}\n"), &new))
+	changes, err := diff.GetYamlNodeChanges(&old, &new)
+	require.NoError(t, err)
+
+	buffer := bytes.Buffer{}
+	require.NoError(t, report.WriteMergePatch(changes, &new, &buffer, false))
+	assertThat(t, buffer.String(), is.EqualTo("labels:\n  tier: null\nname: api\nports: [443, 8443]\n"))
+}
+
+func TestMergePatchMovesRenamedKeys(t *testing.T) {
+	var old, new yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte("servers:\n  web: {host: h, tls: true, port: 1}\n"), &old))
+	require.NoError(t, yaml.Unmarshal([]byte("servers:\n  api: {host: h, tls: true, port: 2}\n"), &new))
+	changes, err := diff.GetYamlNodeChanges(&old, &new, diff.WithRenameSimilarity(0.5))
+	require.NoError(t, err)
+
+	buffer := bytes.Buffer{}
+	require.NoError(t, report.WriteMergePatch(changes, &new, &buffer, true))
+	assertThat(t, buffer.String(), is.EqualTo(`{
+  "servers": {
+    "api": {
+      "host": "h",
+      "port": 2,
+      "tls": true
+    },
+    "web": null
+  }
+}
+`))
+}
+
+func TestMergePatchCantSetNull(t *testing.T) {
+	var old, new yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte("a: 1\n"), &old))
+	require.NoError(t, yaml.Unmarshal([]byte("a: 1\nb: {c: ~}\n"), &new))
+	changes, err := diff.GetYamlNodeChanges(&old, &new)
+	require.NoError(t, err)
+
+	_, err = report.MergePatch(changes, &new)
+	assertThat(t, err, is.Not(is.Nil()))
+	assertThat(t, err.Error(), is.EqualTo("a merge patch can't set doc.b.c to null"))
+}
+
+// applyMergePatch is the RFC 7396 algorithm
+func applyMergePatch(target, patch interface{}) interface{} {
+	fields, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetFields, ok := target.(map[string]interface{})
+	if !ok {
+		targetFields = map[string]interface{}{}
+	}
+	for key, value := range fields {
+		if value == nil {
+			delete(targetFields, key)
+		} else {
+			targetFields[key] = applyMergePatch(targetFields[key], value)
+		}
+	}
+	return targetFields
+}
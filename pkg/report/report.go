@@ -18,6 +18,10 @@ func WriteChanges(changes []diff.ChangeLogEntry, w io.Writer) error {
 		}
 		copiedChange := change
 		switch {
+		case copiedChange.LineDiff != nil:
+			// the changed lines say more than the whole old and new values
+			copiedChange.From = nil
+			copiedChange.To = nil
 		case copiedChange.ChangeType == diff.Deleted:
 			if copiedChange.From.Kind != yaml.ScalarNode {
 				copiedChange.From = nil
@@ -29,6 +33,11 @@ func WriteChanges(changes []diff.ChangeLogEntry, w io.Writer) error {
 		case copiedChange.ChangeType == diff.Moved:
 			copiedChange.To = nil
 			copiedChange.From = nil
+		case copiedChange.ChangeType == diff.Renamed:
+			if copiedChange.From.Kind != yaml.ScalarNode {
+				copiedChange.To = nil
+				copiedChange.From = nil
+			}
 		}
 		reportChanges[index] = copiedChange
 	}